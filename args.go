@@ -1,56 +1,300 @@
 package flag
 
-import "strings"
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ArgSpec describes the flags of a config so that ParseArgsSpec can resolve
+// tokens that are ambiguous without type information.
+type ArgSpec struct {
+	// Values holds the short flags that take a value. The remainder of a
+	// token following such a flag is read as its value, so -p8080 is the
+	// same as -p 8080 and -vp8080 sets v and p=8080. At the end of a cluster
+	// such a flag takes the next token, so -xvf out.tar sets f=out.tar.
+	Values map[string]bool
+
+	// Lists holds the long and short names of list flags. A list flag
+	// given as --key value absorbs all following tokens up to the next
+	// flag, so --tags a b c is the same as --tags a,b,c.
+	Lists map[string]bool
+
+	// Bools holds the long and short names of bool flags. A bool flag only
+	// takes an inline value, as in --verbose=false, so in --verbose cmd the
+	// cmd is a positional argument.
+	Bools map[string]bool
+
+	// Positionals is the number of positional arguments the config declares.
+	// A list flag leaves as many of its trailing tokens positional as are
+	// still missing, so with one positional --tags a b c cmd sets tags to
+	// a,b,c and keeps cmd positional. A list flag always takes its first
+	// token.
+	Positionals int
+}
+
+// NewArgSpec builds an ArgSpec from the fields of the config struct.
+// Every non-bool field with a short name takes a value, slice fields tagged
+// greedy:"true" are list flags and bool fields are bool flags, including the
+// fields of nested structs under their dotted names. A *bool counts as a bool.
+// Each field tagged arg counts as one positional.
+func NewArgSpec(config interface{}) ArgSpec {
+	spec := ArgSpec{Values: make(map[string]bool), Lists: make(map[string]bool), Bools: make(map[string]bool)}
+	v := reflect.ValueOf(config)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return spec
+	}
+
+	for _, fieldType := range structFields(v) {
+		if fieldType.Tag.Get("arg") != "" {
+			spec.Positionals++
+			continue
+		}
+		greedy := fieldType.Type.Kind() == reflect.Slice && fieldType.Tag.Get("greedy") == "true"
+		if isBool(fieldType.Type) {
+			spec.Bools[flagName(fieldType.StructField)] = true
+		}
+		for _, shortName := range shortNames(fieldType.StructField) {
+			if isBool(fieldType.Type) {
+				spec.Bools[shortName] = true
+			} else {
+				spec.Values[shortName] = true
+			}
+			if greedy {
+				spec.Lists[shortName] = true
+			}
+		}
+		if greedy {
+			spec.Lists[flagName(fieldType.StructField)] = true
+		}
+	}
+
+	// Fields of nested structs, as --log.verbose or --log/verbose
+	for _, target := range flagTargets(structFields(v), "", "") {
+		name := target.keys[0]
+		if !strings.Contains(name, ".") {
+			continue
+		}
+		for _, key := range []string{name, strings.ReplaceAll(name, ".", "/")} {
+			if isBool(target.Type) {
+				spec.Bools[key] = true
+			}
+			if target.Type.Kind() == reflect.Slice && target.Tag.Get("greedy") == "true" {
+				spec.Lists[key] = true
+			}
+		}
+	}
+	return spec
+}
+
+// isBool reports whether t is a bool or a pointer to a bool.
+func isBool(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Bool
+}
+
+// Flag is a single flag as it appeared on the command line.
+type Flag struct {
+	Key   string
+	Value string
+
+	// HasValue is false for a flag given without a value, as in --color,
+	// and true when a value was given, even an empty one as in --color=.
+	HasValue bool
+
+	// Token is the argument the flag was parsed from, such as -abc for b.
+	// Pos is the index of the flag's letter in Token for short flags, so 2
+	// for b in -abc, and 0 for long flags.
+	Token string
+	Pos   int
+}
+
+// Flags is a list of parsed flags in command-line order.
+type Flags []Flag
+
+// Map returns the flags as a map, with later flags overriding earlier flags
+// with the same key.
+func (f Flags) Map() map[string]string {
+	m := make(map[string]string, len(f))
+	for _, flag := range f {
+		m[flag.Key] = flag.Value
+	}
+	return m
+}
+
+// Get returns the value of the last flag with the key.
+func (f Flags) Get(key string) (string, bool) {
+	for i := len(f) - 1; i >= 0; i-- {
+		if f[i].Key == key {
+			return f[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// flagsFromMap converts a flags map into Flags. Long flags are ordered before
+// short flags, so a short flag wins over its long form. The map can't tell
+// --key= from --key, so empty values are taken as flags without a value.
+func flagsFromMap(m map[string]string) Flags {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		li, lj := utf8.RuneCountInString(keys[i]) > 1, utf8.RuneCountInString(keys[j]) > 1
+		if li != lj {
+			return li
+		}
+		return keys[i] < keys[j]
+	})
+	flags := make(Flags, len(keys))
+	for i, key := range keys {
+		flags[i] = Flag{Key: key, Value: m[key], HasValue: m[key] != ""}
+	}
+	return flags
+}
+
+// inlineSeparator separates a flag from an inline value, as in --key=value.
+var inlineSeparator byte = '='
+
+// SetInlineSeparator sets the character separating a flag from an inline
+// value, which defaults to '='. Only the first occurrence splits the token,
+// so with ':' --url:http://x sets url to http://x.
+func SetInlineSeparator(sep byte) {
+	inlineSeparator = sep
+}
 
 // Parses out positional arguments, flags and shorthand flags from the slice
 func ParseArgs(args []string) (positionalArgs []string, flags map[string]string) {
+	return ParseArgsSpec(args, ArgSpec{})
+}
+
+// ParseArgsSpec is like ParseArgs but uses spec to resolve short flag tokens
+// whose meaning depends on the flag types.
+//
+// Without a spec -p8080 is read as the combined boolean flags p, 8, 0, 8 and 0.
+// With p in spec.Values it is read as p=8080 instead.
+func ParseArgsSpec(args []string, spec ArgSpec) (positionalArgs []string, flags map[string]string) {
+	positionalArgs, ordered := ParseArgsOrdered(args, spec)
+	return positionalArgs, ordered.Map()
+}
+
+// ParseArgsOrdered is like ParseArgsSpec but returns the flags in the order
+// they appeared, including repeated flags.
+//
+// A lone - is a positional argument, and all arguments after -- are
+// positional, even when they start with a dash. Tokens without a flag name,
+// such as --=value and -=x, are positional as well.
+func ParseArgsOrdered(args []string, spec ArgSpec) (positionalArgs []string, flags Flags) {
 	positionalArgs = []string{}
-	flags = make(map[string]string)
+	flags = Flags{}
 
 	i := 0
 	for i < len(args) {
 		arg := args[i]
+		start := len(flags)
 		hasMoreArgs := i+1 < len(args)
 		nextArgIsValue := hasMoreArgs && !strings.HasPrefix(args[i+1], "-")
 
-		if strings.HasPrefix(arg, "--") {
+		if arg == "--" {
+			// Everything after -- is positional
+			positionalArgs = append(positionalArgs, args[i+1:]...)
+			break
+		} else if emptyKey(arg) {
+			// Tokens like --=value name no flag
+			positionalArgs = append(positionalArgs, arg)
+		} else if strings.HasPrefix(arg, "--") {
 			key := arg[2:]
-			if strings.Contains(key, "=") {
+			if sep := strings.IndexByte(key, inlineSeparator); sep >= 0 {
 				// Handle --key=value
-				parts := strings.SplitN(key, "=", 2)
-				flags[parts[0]] = parts[1]
-			} else if nextArgIsValue {
+				flags = append(flags, Flag{Key: key[:sep], Value: key[sep+1:], HasValue: true})
+			} else if nextArgIsValue && !spec.Bools[key] {
 				// Handle --key value
-				flags[key] = args[i+1]
-				i++ // Skip next arg as it's a value
+				var value string
+				value, i = takeValue(args, i, spec.Lists[key], spec.Positionals-len(positionalArgs))
+				flags = append(flags, Flag{Key: key, Value: value, HasValue: true})
 			} else {
 				// Handle --key
-				flags[key] = ""
+				flags = append(flags, Flag{Key: key, Value: "", HasValue: false})
 			}
 		} else if strings.HasPrefix(arg, "-") && len(arg) > 1 {
-			if len(arg) == 2 || strings.Contains(arg[2:], "=") {
-				// Handle -k value or -k=value
-				if strings.Contains(arg[2:], "=") {
-					parts := strings.SplitN(arg[2:], "=", 2)
-					flags[parts[0]] = parts[1]
-				} else if nextArgIsValue {
-					flags[arg[1:2]] = args[i+1]
-					i++ // Skip next arg as it's a value
-				} else {
-					flags[arg[1:2]] = ""
+			cluster := arg[1:]
+			for j := range cluster {
+				_, size := utf8.DecodeRuneInString(cluster[j:])
+				name := cluster[j : j+size]
+				rest := cluster[j+size:]
+				if rest != "" && rest[0] == inlineSeparator {
+					// Handle -k=value
+					flags = append(flags, Flag{Key: name, Value: rest[1:], HasValue: true})
+					break
 				}
-			} else {
-				// Handle combined flags like -abc
-				for _, flag := range arg[1:] {
-					flags[string(flag)] = ""
+				if rest == "" {
+					if (j == 0 || spec.Values[name]) && nextArgIsValue && !spec.Bools[name] {
+						// Handle -k value, or -xvf value when f takes a value
+						var value string
+						value, i = takeValue(args, i, spec.Lists[name], spec.Positionals-len(positionalArgs))
+						flags = append(flags, Flag{Key: name, Value: value, HasValue: true})
+					} else {
+						flags = append(flags, Flag{Key: name, Value: "", HasValue: false})
+					}
+					break
 				}
+				if spec.Values[name] {
+					// Handle -kvalue
+					flags = append(flags, Flag{Key: name, Value: rest, HasValue: true})
+					break
+				}
+				// Handle combined flags like -abc
+				flags = append(flags, Flag{Key: name, Value: "", HasValue: false})
 			}
 		} else {
 			// Positional arguments
 			positionalArgs = append(positionalArgs, arg)
 		}
+		for k := start; k < len(flags); k++ {
+			flags[k].Token = arg
+			if !strings.HasPrefix(arg, "--") {
+				// A cluster adds one flag per letter, in order
+				flags[k].Pos = 1 + k - start
+			}
+		}
 		i++
 	}
 
 	return positionalArgs, flags
 }
+
+// emptyKey reports whether arg is a flag token without a flag name, such as
+// --=value or -=x.
+func emptyKey(arg string) bool {
+	key := strings.TrimPrefix(arg, "-")
+	key = strings.TrimPrefix(key, "-")
+	return len(key) < len(arg) && key != "" && key[0] == inlineSeparator
+}
+
+// takeValue returns the value following the flag at args[i] and the index of
+// the last token consumed. A list flag takes all following tokens up to the
+// next flag, joined into a comma-separated list, except for up to reserved
+// trailing tokens that are left for positional arguments.
+func takeValue(args []string, i int, list bool, reserved int) (string, int) {
+	i++
+	if !list {
+		return args[i], i
+	}
+	values := []string{args[i]}
+	for i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+		i++
+		values = append(values, args[i])
+	}
+	if keep := max(len(values)-reserved, 1); keep < len(values) {
+		i -= len(values) - keep
+		values = values[:keep]
+	}
+	return joinList(values), i
+}
@@ -2,6 +2,7 @@ package flag_test
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	. "github.com/bartdeboer/flag"
@@ -50,6 +51,18 @@ func TestParseArguments(t *testing.T) {
 			expectedCommands: []string{},
 			expectedArgsMap:  map[string]string{"k": "value"},
 		},
+		{
+			name:             "Shorthand with inline value",
+			args:             []string{"-p=8080"},
+			expectedCommands: []string{},
+			expectedArgsMap:  map[string]string{"p": "8080"},
+		},
+		{
+			name:             "Shorthand with attached value without spec",
+			args:             []string{"-p80"},
+			expectedCommands: []string{},
+			expectedArgsMap:  map[string]string{"p": "", "8": "", "0": ""},
+		},
 		{
 			name:             "Shorthand and long mix",
 			args:             []string{"-k", "value", "--long=value2", "cmd", "--bool"},
@@ -70,3 +83,342 @@ func TestParseArguments(t *testing.T) {
 		})
 	}
 }
+
+func TestParseArgsSpec(t *testing.T) {
+	type Config struct {
+		Port    int  `short:"p"`
+		Verbose bool `short:"v"`
+		Quiet   bool `short:"q"`
+	}
+	spec := NewArgSpec(&Config{})
+
+	testCases := []struct {
+		name             string
+		args             []string
+		expectedCommands []string
+		expectedArgsMap  map[string]string
+	}{
+		{
+			name:             "Attached value",
+			args:             []string{"-p8080"},
+			expectedCommands: []string{},
+			expectedArgsMap:  map[string]string{"p": "8080"},
+		},
+		{
+			name:             "Inline value",
+			args:             []string{"-p=8080"},
+			expectedCommands: []string{},
+			expectedArgsMap:  map[string]string{"p": "8080"},
+		},
+		{
+			name:             "Separate value",
+			args:             []string{"-p", "8080", "cmd"},
+			expectedCommands: []string{"cmd"},
+			expectedArgsMap:  map[string]string{"p": "8080"},
+		},
+		{
+			name:             "Booleans followed by attached value",
+			args:             []string{"-vqp8080"},
+			expectedCommands: []string{},
+			expectedArgsMap:  map[string]string{"v": "", "q": "", "p": "8080"},
+		},
+		{
+			name:             "Combined booleans",
+			args:             []string{"-vq"},
+			expectedCommands: []string{},
+			expectedArgsMap:  map[string]string{"v": "", "q": ""},
+		},
+		{
+			name:             "Cluster ending in a value flag",
+			args:             []string{"-vqp", "8080", "cmd"},
+			expectedCommands: []string{"cmd"},
+			expectedArgsMap:  map[string]string{"v": "", "q": "", "p": "8080"},
+		},
+		{
+			name:             "Booleans followed by positionals",
+			args:             []string{"-v", "cmd", "--quiet", "arg"},
+			expectedCommands: []string{"cmd", "arg"},
+			expectedArgsMap:  map[string]string{"v": "", "quiet": ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			commands, argsMap := ParseArgsSpec(tc.args, spec)
+			if !reflect.DeepEqual(commands, tc.expectedCommands) {
+				t.Errorf("Failed %s, Commands got: %v, want: %v", tc.name, commands, tc.expectedCommands)
+			}
+			if !reflect.DeepEqual(argsMap, tc.expectedArgsMap) {
+				t.Errorf("Failed %s, ArgsMap got: %v, want: %v", tc.name, argsMap, tc.expectedArgsMap)
+			}
+		})
+	}
+}
+
+func TestParseArgsSpecLists(t *testing.T) {
+	type Config struct {
+		Tags  []string `short:"t" greedy:"true"`
+		Other []string
+		Name  string
+	}
+	spec := NewArgSpec(&Config{})
+
+	testCases := []struct {
+		name             string
+		args             []string
+		expectedCommands []string
+		expectedArgsMap  map[string]string
+	}{
+		{
+			name:             "Positional before list",
+			args:             []string{"cmd", "--tags", "a", "b", "c"},
+			expectedCommands: []string{"cmd"},
+			expectedArgsMap:  map[string]string{"tags": "a,b,c"},
+		},
+		{
+			name:             "List ended by a flag",
+			args:             []string{"--tags", "a", "b", "c", "--name=x", "cmd"},
+			expectedCommands: []string{"cmd"},
+			expectedArgsMap:  map[string]string{"tags": "a,b,c", "name": "x"},
+		},
+		{
+			name:             "List absorbs trailing tokens without positional fields",
+			args:             []string{"--tags", "a", "b", "c", "cmd"},
+			expectedCommands: []string{},
+			expectedArgsMap:  map[string]string{"tags": "a,b,c,cmd"},
+		},
+		{
+			name:             "Short list with comma in value",
+			args:             []string{"-t", "a,b", "c"},
+			expectedCommands: []string{},
+			expectedArgsMap:  map[string]string{"t": `"a,b",c`},
+		},
+		{
+			name:             "Non-greedy slice",
+			args:             []string{"--other", "a", "b"},
+			expectedCommands: []string{"b"},
+			expectedArgsMap:  map[string]string{"other": "a"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			commands, argsMap := ParseArgsSpec(tc.args, spec)
+			if !reflect.DeepEqual(commands, tc.expectedCommands) {
+				t.Errorf("Failed %s, Commands got: %v, want: %v", tc.name, commands, tc.expectedCommands)
+			}
+			if !reflect.DeepEqual(argsMap, tc.expectedArgsMap) {
+				t.Errorf("Failed %s, ArgsMap got: %v, want: %v", tc.name, argsMap, tc.expectedArgsMap)
+			}
+		})
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"-t", "a,b", "c", "--name", "x", "cmd"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(config.Tags, []string{"a,b", "c"}) {
+		t.Errorf("Expected tags [a,b c], got %q", config.Tags)
+	}
+
+	// Trailing tokens are left for the declared positional arguments
+	type Positional struct {
+		Tags    []string `greedy:"true"`
+		Command string   `arg:"command"`
+	}
+	positionalCases := []struct {
+		args     []string
+		tags     []string
+		expected Positional
+	}{
+		{[]string{"--tags", "a", "b", "c", "cmd"}, []string{"a", "b", "c"}, Positional{Command: "cmd"}},
+		{[]string{"cmd", "--tags", "a", "b", "c"}, []string{"a", "b", "c"}, Positional{Command: "cmd"}},
+		{[]string{"--tags", "a"}, []string{"a"}, Positional{}},
+	}
+	for _, tc := range positionalCases {
+		var positional Positional
+		if _, _, err := ParseAll(&positional, tc.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tc.args, err)
+		}
+		tc.expected.Tags = tc.tags
+		if !reflect.DeepEqual(positional, tc.expected) {
+			t.Errorf("ParseAll(%v): expected %+v, got %+v", tc.args, tc.expected, positional)
+		}
+	}
+}
+
+func TestSetInlineSeparator(t *testing.T) {
+	SetInlineSeparator(':')
+	defer SetInlineSeparator('=')
+
+	commands, argsMap := ParseArgs([]string{"--host:localhost", "-p:8080", "--url:http://x:80/a", "--key=value"})
+	expected := map[string]string{"host": "localhost", "p": "8080", "url": "http://x:80/a", "key=value": ""}
+	if len(commands) != 0 {
+		t.Errorf("Expected no commands, got %v", commands)
+	}
+	if !reflect.DeepEqual(argsMap, expected) {
+		t.Errorf("ArgsMap got: %v, want: %v", argsMap, expected)
+	}
+}
+
+func TestParseArgsOrdered(t *testing.T) {
+	commands, flags := ParseArgsOrdered([]string{"--set", "a", "cmd", "--unset=a", "-v", "--set", "b"}, ArgSpec{})
+
+	expected := Flags{
+		{"set", "a", true, "--set", 0},
+		{"unset", "a", true, "--unset=a", 0},
+		{"v", "", false, "-v", 1},
+		{"set", "b", true, "--set", 0},
+	}
+	if !reflect.DeepEqual(commands, []string{"cmd"}) {
+		t.Errorf("Commands got: %v, want: [cmd]", commands)
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Flags got: %v, want: %v", flags, expected)
+	}
+	if value, ok := flags.Get("set"); !ok || value != "b" {
+		t.Errorf("Expected last set value 'b', got '%s' (%v)", value, ok)
+	}
+	expectedMap := map[string]string{"set": "b", "unset": "a", "v": ""}
+	if !reflect.DeepEqual(flags.Map(), expectedMap) {
+		t.Errorf("Map got: %v, want: %v", flags.Map(), expectedMap)
+	}
+}
+
+func TestGetFlag(t *testing.T) {
+	_, flags := ParseArgs([]string{"--port", "8080", "--verbose", "--name=x"})
+
+	port, ok, err := GetFlag[int](flags, "port")
+	if err != nil || !ok || port != 8080 {
+		t.Errorf("Expected port 8080, got %d, %v, %v", port, ok, err)
+	}
+	verbose, ok, err := GetFlag[bool](flags, "verbose")
+	if err != nil || !ok || !verbose {
+		t.Errorf("Expected verbose true, got %v, %v, %v", verbose, ok, err)
+	}
+	missing, ok, err := GetFlag[string](flags, "missing")
+	if err != nil || ok || missing != "" {
+		t.Errorf("Expected missing flag to be absent, got '%s', %v, %v", missing, ok, err)
+	}
+	if _, ok, err := GetFlag[int](flags, "name"); !ok || err == nil {
+		t.Errorf("Expected an error parsing --name as int, got %v, %v", ok, err)
+	}
+}
+
+func TestParseArgsOrderedPositions(t *testing.T) {
+	_, flags := ParseArgsOrdered([]string{"-abx", "-p=1"}, ArgSpec{})
+
+	expected := Flags{
+		{"a", "", false, "-abx", 1},
+		{"b", "", false, "-abx", 2},
+		{"x", "", false, "-abx", 3},
+		{"p", "1", true, "-p=1", 1},
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Flags got: %v, want: %v", flags, expected)
+	}
+}
+
+func TestParseArgsEdgeCases(t *testing.T) {
+	testCases := []struct {
+		args       []string
+		positional []string
+		flags      map[string]string
+	}{
+		{[]string{"-"}, []string{"-"}, map[string]string{}},
+		{[]string{"-v", "--", "-x", "--y", "z"}, []string{"-x", "--y", "z"}, map[string]string{"v": ""}},
+		{[]string{"--"}, []string{}, map[string]string{}},
+		{[]string{"-é"}, []string{}, map[string]string{"é": ""}},
+		{[]string{"-éa=1"}, []string{}, map[string]string{"é": "", "a": "1"}},
+		{[]string{"-p="}, []string{}, map[string]string{"p": ""}},
+	}
+
+	for _, tc := range testCases {
+		positional, flags := ParseArgs(tc.args)
+		if !reflect.DeepEqual(positional, tc.positional) {
+			t.Errorf("ParseArgs(%q) positional got: %q, want: %q", tc.args, positional, tc.positional)
+		}
+		if !reflect.DeepEqual(flags, tc.flags) {
+			t.Errorf("ParseArgs(%q) flags got: %q, want: %q", tc.args, flags, tc.flags)
+		}
+	}
+}
+
+func TestParseArgsExplicitEmpty(t *testing.T) {
+	args := []string{"--key", "--key=", "-k", "-k=", "-ab="}
+	_, flags := ParseArgsOrdered(args, ArgSpec{})
+	expected := []bool{false, true, false, true, false, true}
+	if len(flags) != len(expected) {
+		t.Fatalf("Expected %d flags, got %+v", len(expected), flags)
+	}
+	for i, flag := range flags {
+		if flag.Value != "" || flag.HasValue != expected[i] {
+			t.Errorf("Flag %d (%s in %q): got value %q and HasValue %v, want empty and %v", i, flag.Key, flag.Token, flag.Value, flag.HasValue, expected[i])
+		}
+	}
+
+	// The map keeps the empty string for both forms
+	_, m := ParseArgs([]string{"--key="})
+	if value, ok := m["key"]; !ok || value != "" {
+		t.Errorf("Expected key to map to an empty string, got %q, %v", value, ok)
+	}
+}
+
+func TestParseArgsEmptyKey(t *testing.T) {
+	args := []string{"--=value", "-=x", "=sign", "-v"}
+	positional, flags := ParseArgs(args)
+	if _, ok := flags[""]; ok {
+		t.Errorf("Expected no empty key, got %v", flags)
+	}
+	if _, ok := flags["="]; ok {
+		t.Errorf("Expected no = key, got %v", flags)
+	}
+	if !reflect.DeepEqual(positional, []string{"--=value", "-=x", "=sign"}) {
+		t.Errorf("Expected the tokens without a flag name as positionals, got %v", positional)
+	}
+	if !reflect.DeepEqual(flags, map[string]string{"v": ""}) {
+		t.Errorf("Expected only v, got %v", flags)
+	}
+}
+
+func FuzzParseArgs(f *testing.F) {
+	for _, seed := range []string{"-=x", "-", "--", "-é", "--=v", "-abc\x00value", "--key=\x00-p8080", "\xff-\xfe"} {
+		f.Add(seed)
+	}
+	spec := ArgSpec{Values: map[string]bool{"p": true}, Lists: map[string]bool{"tags": true}}
+	f.Fuzz(func(t *testing.T, input string) {
+		args := strings.Split(input, "\x00")
+		ParseArgs(args)
+		ParseArgsOrdered(args, spec)
+	})
+}
+
+func TestParseInto(t *testing.T) {
+	m := map[string]interface{}{"ratio": 0.0, "tags": []string{}}
+	args := []string{"run", "--n=5", "--flag", "--name=x", "--off=false", "--ratio=0.5", "--tags", "a,b", "--big=99999999999999999999"}
+
+	positional, err := ParseInto(m, args)
+	if err != nil {
+		t.Fatalf("ParseInto failed: %v", err)
+	}
+	if !reflect.DeepEqual(positional, []string{"run"}) {
+		t.Errorf("Expected positional [run], got %v", positional)
+	}
+	expected := map[string]interface{}{
+		"n":     5,
+		"flag":  true,
+		"name":  "x",
+		"off":   false,
+		"ratio": 0.5,
+		"tags":  []string{"a", "b"},
+		"big":   "99999999999999999999",
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected %v, got %v", expected, m)
+	}
+
+	m = map[string]interface{}{"n": 0}
+	if _, err := ParseInto(m, []string{"--n=x"}); err == nil {
+		t.Error("Expected an error parsing --n=x as int")
+	}
+}
@@ -0,0 +1,129 @@
+package flag
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Command is a subcommand of a program, such as serve in app serve --port=80.
+type Command struct {
+	Name  string
+	Usage string
+
+	// Config is a pointer to the config struct of the command, parsed with
+	// ParseAll from the arguments without the command name. It may be nil
+	// for a command without flags.
+	Config interface{}
+
+	// Run is called with the positional arguments left after parsing.
+	Run func(ctx context.Context, args []string) error
+}
+
+// Dispatcher routes the arguments of a program to one of its commands.
+type Dispatcher struct {
+	Commands []*Command
+}
+
+// NewDispatcher returns a Dispatcher for the commands.
+func NewDispatcher(commands ...*Command) *Dispatcher {
+	return &Dispatcher{Commands: commands}
+}
+
+// Dispatch runs the command named by the first positional argument in args,
+// after parsing the remaining arguments into its config, so in
+// app --port=80 serve dir the serve command gets port 80 and the argument dir.
+// The flags of all commands are taken into account to find the command, so in
+// app --verbose serve the bool flag doesn't take serve as its value.
+// Without a command, --help and -h print the commands and return ErrHelp.
+func (d *Dispatcher) Dispatch(ctx context.Context, args []string) error {
+	spec := d.argSpec()
+	positionalArgs, flags := ParseArgsSpec(args, spec)
+	if len(positionalArgs) == 0 {
+		_, help := flags["help"]
+		_, h := flags["h"]
+		if autoHelp && (help || h) {
+			d.PrintCommands(helpOutput())
+			return ErrHelp
+		}
+		return fmt.Errorf("no command given, expected one of %s", d.names())
+	}
+
+	name := positionalArgs[0]
+	var cmd *Command
+	for _, c := range d.Commands {
+		if c.Name == name {
+			cmd = c
+			break
+		}
+	}
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q, expected one of %s", name, d.names())
+	}
+
+	if cmd.Run == nil {
+		return fmt.Errorf("command %s has no Run function", cmd.Name)
+	}
+
+	i := commandIndex(args, spec)
+	cmdArgs := append(slices.Clone(args[:i]), args[i+1:]...)
+	if cmd.Config != nil {
+		var err error
+		if cmdArgs, _, err = ParseAllContext(ctx, cmd.Config, cmdArgs); err != nil {
+			return err // Unwrapped, so ErrHelp can be checked for
+		}
+	}
+	return cmd.Run(ctx, cmdArgs)
+}
+
+// argSpec returns an ArgSpec combining the flags of all commands, with the
+// command name as positional so a list flag doesn't absorb it.
+func (d *Dispatcher) argSpec() ArgSpec {
+	spec := ArgSpec{Values: make(map[string]bool), Lists: make(map[string]bool), Bools: make(map[string]bool), Positionals: 1}
+	for _, c := range d.Commands {
+		if c.Config == nil {
+			continue
+		}
+		cmdSpec := NewArgSpec(c.Config)
+		maps.Copy(spec.Values, cmdSpec.Values)
+		maps.Copy(spec.Lists, cmdSpec.Lists)
+		maps.Copy(spec.Bools, cmdSpec.Bools)
+	}
+	return spec
+}
+
+// commandIndex returns the index in args of the first positional argument,
+// which must exist. It is the last token of the shortest prefix of args that
+// has a positional argument, as a token is only consumed by earlier flags.
+func commandIndex(args []string, spec ArgSpec) int {
+	for i := range args {
+		if positionalArgs, _ := ParseArgsSpec(args[:i+1], spec); len(positionalArgs) > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// PrintCommands writes the names and usage of the commands to w.
+func (d *Dispatcher) PrintCommands(w io.Writer) {
+	width := 0
+	for _, c := range d.Commands {
+		width = max(width, len(c.Name))
+	}
+	fmt.Fprintf(w, "%s:\n", message("commands_header"))
+	for _, c := range d.Commands {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, c.Name, c.Usage)
+	}
+}
+
+// names returns the command names, separated by commas.
+func (d *Dispatcher) names() string {
+	names := make([]string, len(d.Commands))
+	for i, c := range d.Commands {
+		names[i] = c.Name
+	}
+	return strings.Join(names, ", ")
+}
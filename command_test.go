@@ -0,0 +1,77 @@
+package flag_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/bartdeboer/flag"
+)
+
+func TestDispatcher(t *testing.T) {
+	type ServeConfig struct {
+		Port    int  `short:"p" default:"80"`
+		Verbose bool `short:"v"`
+	}
+	var serveConfig ServeConfig
+	var ran string
+	var gotArgs []string
+	run := func(name string) func(ctx context.Context, args []string) error {
+		return func(ctx context.Context, args []string) error {
+			ran, gotArgs = name, args
+			return nil
+		}
+	}
+	d := NewDispatcher(
+		&Command{Name: "serve", Usage: "Serve files", Config: &serveConfig, Run: run("serve")},
+		&Command{Name: "version", Usage: "Print the version", Run: run("version")},
+	)
+
+	if err := d.Dispatch(context.Background(), []string{"--port=8080", "serve", "dir"}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if ran != "serve" || serveConfig.Port != 8080 || !reflect.DeepEqual(gotArgs, []string{"dir"}) {
+		t.Errorf("Expected serve with port 8080 and args [dir], got %s with %+v and %v", ran, serveConfig, gotArgs)
+	}
+
+	serveConfig = ServeConfig{}
+	if err := d.Dispatch(context.Background(), []string{"--verbose", "serve", "dir"}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if ran != "serve" || !serveConfig.Verbose || !reflect.DeepEqual(gotArgs, []string{"dir"}) {
+		t.Errorf("Expected verbose serve with args [dir], got %s with %+v and %v", ran, serveConfig, gotArgs)
+	}
+
+	if err := d.Dispatch(context.Background(), []string{"version"}); err != nil || ran != "version" {
+		t.Errorf("Expected version to run, got %s and %v", ran, err)
+	}
+
+	err := d.Dispatch(context.Background(), []string{"deploy"})
+	if err == nil || err.Error() != `unknown command "deploy", expected one of serve, version` {
+		t.Errorf("Expected error for an unknown command, got %v", err)
+	}
+	err = NewDispatcher(&Command{Name: "noop"}).Dispatch(context.Background(), []string{"noop"})
+	if err == nil || err.Error() != "command noop has no Run function" {
+		t.Errorf("Expected error for a command without Run, got %v", err)
+	}
+	err = d.Dispatch(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "no command given") {
+		t.Errorf("Expected error for a missing command, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(nil)
+	if err := d.Dispatch(context.Background(), []string{"--help"}); !errors.Is(err, ErrHelp) {
+		t.Errorf("Expected ErrHelp, got %v", err)
+	}
+	expected := "Commands:\n" +
+		"  serve    Serve files\n" +
+		"  version  Print the version\n"
+	if buf.String() != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, buf.String())
+	}
+}
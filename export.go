@@ -0,0 +1,185 @@
+package flag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DumpConfig returns the resolved values of config as indented JSON keyed by
+// long flag name, in declaration order. Fields tagged secret:"true" are shown
+// as "***".
+func DumpConfig(config interface{}) string {
+	v := reflect.ValueOf(config)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return "{}"
+	}
+
+	fields := structFields(v)
+	if len(fields) == 0 {
+		return "{}"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, sf := range fields {
+		var value interface{} = "***"
+		if sf.Tag.Get("secret") != "true" {
+			value = jsonValue(sf.Value)
+		}
+		key, _ := json.Marshal(flagName(sf.StructField))
+		data, err := json.MarshalIndent(value, "  ", "  ")
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(value))
+		}
+		fmt.Fprintf(&buf, "  %s: %s", key, data)
+		if i < len(fields)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// jsonValue returns the value to encode for v. Types without their own JSON
+// or text encoding that implement fmt.Stringer are rendered as strings.
+func jsonValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+	value := v.Interface()
+	switch value.(type) {
+	case json.Marshaler, encoding.TextMarshaler:
+		return value
+	case fmt.Stringer:
+		return value.(fmt.Stringer).String()
+	}
+	if v.CanAddr() {
+		if stringer, ok := v.Addr().Interface().(fmt.Stringer); ok {
+			return stringer.String()
+		}
+	}
+	return value
+}
+
+// ExportEnv writes the current values of config to w as NAME=value lines,
+// using the environment variable names ParseEnv reads. Slices are written as
+// comma-separated lists. Fields tagged secret:"true" or source:"flag" are left
+// out.
+func ExportEnv(config interface{}, w io.Writer) error {
+	v := reflect.ValueOf(config)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return errors.New("config must be a pointer to a struct")
+	}
+
+	bw := bufio.NewWriter(w)
+	walkFields(v, "", func(sf structField, path string) error {
+		if sf.Tag.Get("secret") == "true" || !allowsSource(sf.StructField, "env") || strings.Contains(path, ".") {
+			return nil // Nested fields are not read from the environment
+		}
+		fmt.Fprintf(bw, "%s=%s\n", envName(sf.StructField), formatValue(sf.Value, sf.Tag))
+		return nil
+	})
+	return bw.Flush()
+}
+
+// MarshalArgs returns the command-line arguments that set the fields of config
+// differing from their defaults, in declaration order, so parsing them into a
+// new config gives the same values. This allows spawning a child process with
+// the same configuration. Flags are written as --name=value, with the
+// separator set with SetInlineSeparator, a true bool as --name and nested
+// struct fields as --nested.name=value. Fields tagged secret:"true",
+// source:"env" or arg are left out. A default tag that doesn't parse is an
+// error.
+func MarshalArgs(config interface{}) ([]string, error) {
+	v := reflect.Indirect(reflect.ValueOf(config))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config must be a struct or a pointer to a struct, got %T", config)
+	}
+	args := []string{}
+	err := walkFields(v, "", func(sf structField, name string) error {
+		if sf.Tag.Get("secret") == "true" || sf.Tag.Get("arg") != "" || !allowsSource(sf.StructField, "flag") {
+			return nil
+		}
+		def := reflect.New(sf.Type).Elem()
+		if value := defaultString(sf.StructField, os.LookupEnv); value != "" {
+			if err := setField(def, sf.Tag, value, false); err != nil {
+				return fmt.Errorf("error parsing default of field %s: %v", sf.Name, err)
+			}
+		}
+		if reflect.DeepEqual(sf.Value.Interface(), def.Interface()) {
+			return nil
+		}
+		if sf.Value.Kind() == reflect.Ptr && sf.Value.IsNil() {
+			args = append(args, "--"+name+string(inlineSeparator)+sf.Tag.Get("nullable"))
+			return nil
+		}
+		if isBool(sf.Type) && reflect.Indirect(sf.Value).Bool() {
+			args = append(args, "--"+name)
+			return nil
+		}
+		args = append(args, "--"+name+string(inlineSeparator)+formatValue(sf.Value, sf.Tag))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// formatValue formats v as a string that setField parses back into the same
+// value. Fields tagged as:"json" are written as JSON.
+func formatValue(v reflect.Value, tag reflect.StructTag) string {
+	if tag.Get("as") == "json" {
+		if data, err := json.Marshal(v.Interface()); err == nil {
+			return string(data)
+		}
+	}
+	if v.Type() == timeType {
+		layout := tag.Get("layout")
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		return v.Interface().(time.Time).Format(layout)
+	}
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return tag.Get("nullable") // The sentinel, which parses back to nil
+	}
+	if s, ok := stringValue(v); ok {
+		return s
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		return formatValue(v.Elem(), tag)
+	case reflect.Slice:
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = formatValue(v.Index(i), tag)
+		}
+		return joinList(values)
+	case reflect.Map:
+		entries := make([]string, 0, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			entries = append(entries, formatValue(iter.Key(), tag)+"="+formatValue(iter.Value(), tag))
+		}
+		sort.Strings(entries)
+		return joinList(entries)
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,159 @@
+package flag_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/bartdeboer/flag"
+)
+
+func TestDumpConfig(t *testing.T) {
+	type Config struct {
+		PortNumber int      `flag:"port" default:"8080"`
+		HostName   string   `default:"localhost"`
+		Password   string   `secret:"true" default:"hunter2"`
+		Tags       []string `default:"a,b"`
+		Verbose    bool
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--verbose"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+
+	expected := `{
+  "port": 8080,
+  "host-name": "localhost",
+  "password": "***",
+  "tags": [
+    "a",
+    "b"
+  ],
+  "verbose": true
+}`
+	output := DumpConfig(&config)
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestExportEnv(t *testing.T) {
+	type Config struct {
+		PortNumber int      `flag:"port" default:"8080"`
+		HostName   string   `env:"HOST" default:"localhost"`
+		Password   string   `secret:"true" default:"hunter2"`
+		Tags       []string `default:"a,b"`
+		Verbose    bool
+		Skipped    string `flag:"-"`
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--verbose", "--tags", "x,\"y,z\""}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportEnv(&config, &buf); err != nil {
+		t.Fatalf("ExportEnv failed: %v", err)
+	}
+
+	expected := `PORT_NUMBER=8080
+HOST=localhost
+TAGS=x,"y,z"
+VERBOSE=true
+`
+	if buf.String() != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, buf.String())
+	}
+
+	var imported Config
+	env := map[string]string{"PORT_NUMBER": "8080", "HOST": "localhost", "TAGS": `x,"y,z"`, "VERBOSE": "true"}
+	if err := ParseEnvFrom(&imported, env); err != nil {
+		t.Fatalf("ParseEnvFrom failed: %v", err)
+	}
+	if len(imported.Tags) != 2 || imported.Tags[1] != "y,z" {
+		t.Errorf("Expected exported tags to read back as [x y,z], got %v", imported.Tags)
+	}
+}
+
+func TestMarshalArgs(t *testing.T) {
+	type Server struct {
+		Host string `default:"localhost"`
+		TLS  bool
+	}
+	type Config struct {
+		Port     int      `default:"8080"`
+		Color    bool     `default:"true"`
+		Verbose  bool     `short:"v"`
+		Tags     []string `default:"a"`
+		Labels   map[string]string
+		Timeout  time.Duration `default:"30s"`
+		Server   Server
+		Password string             `secret:"true"`
+		Name     string             `default:"app"`
+		Filter   struct{ A string } `as:"json"`
+	}
+
+	var config Config
+	args := []string{"--port=9090", "--color=false", "-v", "--tags", `x,"y,z"`, "--labels=b=2,a=1", "--timeout=1m", "--server.host=example.com", "--server.tls", `--filter={"A":"a"}`}
+	if _, _, err := ParseAll(&config, args); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+
+	marshaled, err := MarshalArgs(&config)
+	if err != nil {
+		t.Fatalf("MarshalArgs failed: %v", err)
+	}
+	expected := []string{"--port=9090", "--color=false", "--verbose", `--tags=x,"y,z"`, "--labels=a=1,b=2", "--timeout=1m0s", "--server.host=example.com", "--server.tls", `--filter={"A":"a"}`}
+	if !reflect.DeepEqual(marshaled, expected) {
+		t.Errorf("Expected %q, got %q", expected, marshaled)
+	}
+
+	var parsed Config
+	if _, _, err := ParseAll(&parsed, marshaled); err != nil {
+		t.Fatalf("ParseAll of marshaled args failed: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, config) {
+		t.Errorf("Expected round trip to give %+v, got %+v", config, parsed)
+	}
+	type Proxied struct {
+		Proxy  *string `default:"http://proxy:3128" nullable:"none"`
+		DryRun *bool   `default:"true" nullable:"none"`
+	}
+	var proxied Proxied
+	if _, _, err := ParseAll(&proxied, []string{"--proxy=none", "--dry-run=none"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	marshaled, err = MarshalArgs(&proxied)
+	if err != nil || !reflect.DeepEqual(marshaled, []string{"--proxy=none", "--dry-run=none"}) {
+		t.Errorf("Expected [--proxy=none --dry-run=none], got %q, %v", marshaled, err)
+	}
+	if _, _, err := ParseAll(&proxied, marshaled); err != nil || proxied.Proxy != nil || proxied.DryRun != nil {
+		t.Errorf("Expected the sentinels to parse back to nil, got %+v, %v", proxied, err)
+	}
+
+	SetInlineSeparator(':')
+	defer SetInlineSeparator('=')
+	type Listener struct {
+		Port int `default:"8080"`
+		Host string
+	}
+	var listener Listener
+	if _, _, err := ParseAll(&listener, []string{"--port:9090"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	marshaled, _ = MarshalArgs(&listener)
+	if !reflect.DeepEqual(marshaled, []string{"--port:9090"}) {
+		t.Errorf("Expected [--port:9090], got %q", marshaled)
+	}
+
+	type Broken struct {
+		Port int `default:"eighty"`
+	}
+	if _, err := MarshalArgs(&Broken{}); err == nil || !strings.Contains(err.Error(), "error parsing default of field Port") {
+		t.Errorf("Expected error for an invalid default, got %v", err)
+	}
+}
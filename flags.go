@@ -1,200 +1,684 @@
 package flag
 
 import (
+	"context"
 	"encoding"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/bartdeboer/words"
 )
 
 // PrintDefaults generates a help page for the CLI based on struct tags with default values and types.
 func PrintDefaults(config interface{}) {
+	FprintDefaults(helpOutput(), config)
+}
+
+// FprintDefaults is like PrintDefaults but writes the help page to w.
+func FprintDefaults(w io.Writer, config interface{}) {
+	printDefaults(w, config, false, os.LookupEnv)
+}
+
+// PrintValues prints the same help page as PrintDefaults, but with the current
+// value of each field instead of its default tag, as in (current 3000). Call
+// it after parsing to show the values the program will use.
+func PrintValues(config interface{}) {
+	printDefaults(helpOutput(), config, true, os.LookupEnv)
+}
+
+// printDefaults is PrintDefaults writing to w, or PrintValues with values set.
+// NO_COLOR and COLUMNS are looked up with lookupEnv.
+func printDefaults(w io.Writer, config interface{}, values bool, lookupEnv func(string) (string, bool)) {
 	val := reflect.ValueOf(config)
 	if val.Kind() == reflect.Ptr {
 		val = val.Elem()
 	}
 
 	if val.Kind() != reflect.Struct {
-		fmt.Println("Expected a struct")
+		fmt.Fprintln(w, "Expected a struct")
 		return
 	}
 
-	typ := val.Type()
 	maxNameTypeLength := 0
-	entries := make([][3]string, val.NumField())
-
-	for i := 0; i < val.NumField(); i++ {
-		field := typ.Field(i)
-		fieldValue := val.Field(i).Interface() // Get the current value of the field
+	var argFields, envFields []structField
+	for _, sf := range structFields(val) {
+		switch {
+		case sf.Tag.Get("arg") != "" || sf.Tag.Get("rest") == "true":
+			argFields = append(argFields, sf)
+		case !allowsSource(sf.StructField, "flag") && !isNestedStruct(sf):
+			envFields = append(envFields, sf)
+		}
+	}
+	fields := flagTargets(structFields(val), "", "")
+	sortByOrder(fields)
+	infos := describe(fields)
+	entries := make([]helpEntry, len(fields))
 
-		usage := field.Tag.Get("usage")
-		short := field.Tag.Get("short")
-		def := field.Tag.Get("default")
-		typeName := field.Type.Name()
-		if field.Type.Kind() == reflect.Ptr {
-			typeName = "*" + field.Type.Elem().Name()
+	maxShortLength := 2
+	for i, info := range infos {
+		sf := fields[i]
+		fieldValue := sf.Value.Interface() // Get the current value of the field
+		if sf.Value.Kind() == reflect.Ptr && !sf.Value.IsNil() && sf.Value.Elem().Kind() != reflect.Struct {
+			fieldValue = sf.Value.Elem().Interface()
 		}
 
 		// Constructing parts of the output
-		shortPart := fmt.Sprintf("-%s", short)
-		if short == "" {
-			shortPart = "  " // Align when no shorthand is present
+		shortPart := ""
+		for _, short := range append([]string{info.Short}, info.Aliases...) {
+			if short != "" {
+				shortPart += ",-" + short
+			}
 		}
-		longPart := fmt.Sprintf("--%s %s", words.ToKebabCase(field.Name), typeName)
+		shortPart = strings.TrimPrefix(shortPart, ",")
+		if n := utf8.RuneCountInString(shortPart); n > maxShortLength {
+			maxShortLength = n
+		}
+		longPart := fmt.Sprintf("--%s %s", info.Name, info.Type)
 
 		// Combine default and current value into one string
 		defaultStr := ""
-		if def != "" && def != "0" && def != "false" && def != "\"\"" {
-			defaultStr = fmt.Sprintf(" (default %v)", def)
+		if info.Default != "" && !values {
+			defaultStr = fmt.Sprintf(" (%s %v)", message("default_prefix"), info.Default)
 		}
 
-		currentStr := fmt.Sprintf(" (current %v)", fieldValue)
-		if fieldValue == reflect.Zero(field.Type).Interface() {
-			currentStr = ""
+		currentStr := fmt.Sprintf(" (%s %v)", message("current_prefix"), fieldValue)
+		if format := sf.Tag.Get("fmt"); format != "" {
+			currentStr = fmt.Sprintf(" (%s %s)", message("current_prefix"), displayValue(format, sf.Value))
+		}
+		if sf.Value.IsZero() || sf.Tag.Get("secret") == "true" {
+			currentStr = "" // Never reveal the values of secrets
 		}
 
-		fullUsage := usage + defaultStr + currentStr
+		fullUsage := info.Usage + defaultStr + currentStr
+		if values {
+			defaultStr = currentStr // Dimmed like the default
+		}
 
 		entry := longPart
-		if len(entry) > maxNameTypeLength {
-			maxNameTypeLength = len(entry)
+		if n := utf8.RuneCountInString(entry); n > maxNameTypeLength {
+			maxNameTypeLength = n
 		}
-		entries[i] = [3]string{shortPart, entry, fullUsage}
+		entries[i] = helpEntry{shortPart, entry, fullUsage, info.Group, info.Required, strings.TrimPrefix(defaultStr, " ")}
 	}
 
-	for _, e := range entries {
-		fmt.Printf("  %s %-*s  %s\n", e[0], maxNameTypeLength, e[1], e[2])
+	// Columns are aligned across all groups so the sections line up. Padding
+	// is computed on the plain text so color codes don't shift the columns,
+	// counting runes so non-ASCII text lines up too.
+	colored := useColor(w, lookupEnv)
+	width := helpWidth
+	if !helpWidthSet {
+		width = terminalWidth(lookupEnv)
+	}
+	for i, section := range groupEntries(entries) {
+		if section.name != "" {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s:\n", section.name)
+		}
+		for _, e := range section.entries {
+			indent := 2 + maxShortLength + 1 + maxNameTypeLength + 2
+			lines := wrapText(e.usage, width-indent)
+			short, name := e.short, e.name
+			shortPadding := strings.Repeat(" ", maxShortLength-utf8.RuneCountInString(e.short))
+			short += shortPadding // Align when no shorthand is present
+			padding := strings.Repeat(" ", maxNameTypeLength-utf8.RuneCountInString(e.name))
+			if colored {
+				if e.short != "" {
+					short = paint(colorName, e.short) + shortPadding
+				}
+				name = paint(colorName, name)
+				for j, line := range lines {
+					if e.def != "" && strings.Contains(line, e.def) {
+						lines[j] = strings.Replace(line, e.def, paint(colorDim, e.def), 1)
+					}
+				}
+			}
+			fmt.Fprintf(w, "  %s %s%s  %s\n", short, name, padding, lines[0])
+			for _, line := range lines[1:] {
+				fmt.Fprintf(w, "%*s%s\n", indent, "", line)
+			}
+		}
+	}
+
+	if len(argFields) > 0 {
+		names := make([]string, len(argFields))
+		for i, sf := range argFields {
+			names[i] = sf.Tag.Get("arg")
+			if sf.Tag.Get("rest") == "true" {
+				names[i] = "-- [" + flagName(sf.StructField) + "...]"
+			} else if sf.Type.Kind() == reflect.Slice {
+				names[i] = "[" + names[i] + "...]"
+			}
+		}
+		printSection(w, message("arguments_header"), names, argFields, len(fields) > 0)
+	}
+	if len(envFields) > 0 {
+		names := make([]string, len(envFields))
+		for i, sf := range envFields {
+			names[i] = envName(sf.StructField) + " " + typeName(sf.Type)
+		}
+		printSection(w, message("environment_header"), names, envFields, len(fields)+len(argFields) > 0)
 	}
 }
 
-// SetDefaults sets default values for fields in the config struct based on struct tags.
-func SetDefaults(config interface{}) error {
+// printSection prints a titled section listing the names of fields that are
+// not flags, such as positional arguments, with their usage.
+func printSection(w io.Writer, title string, names []string, fields []structField, separate bool) {
+	if separate {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s:\n", title)
+	maxLength := 0
+	for _, name := range names {
+		if n := utf8.RuneCountInString(name); n > maxLength {
+			maxLength = n
+		}
+	}
+	for i, sf := range fields {
+		fmt.Fprintf(w, "  %-*s  %s\n", maxLength, names[i], sf.Tag.Get("usage"))
+	}
+}
+
+// FlagInfo describes a flag for generating documentation.
+type FlagInfo struct {
+	Name     string   // Long flag name, without dashes
+	Short    string   // Short flag name, without the dash
+	Aliases  []string // Further short flag names, from a short tag like "v,d"
+	Type     string   // Type name as shown in the help output
+	Default  string   // Default as shown in the help output, empty for zero defaults and secrets
+	Usage    string   // Usage description
+	Group    string   // Help section
+	Required bool     // Whether the field is tagged required:"true"
+}
+
+// Describe returns the flags of the config struct in the order PrintDefaults
+// lists them, for rendering documentation in other formats. The fields of
+// embedded structs are included without a prefix, and those of nested structs
+// with their dotted name, like log.level.
+func Describe(config interface{}) []FlagInfo {
 	v := reflect.ValueOf(config)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
 	if v.Kind() != reflect.Struct {
-		return errors.New("config must be a pointer to a struct")
+		return nil
 	}
-	t := v.Type()
+	fields := flagTargets(structFields(v), "", "")
+	sortByOrder(fields)
+	return describe(fields)
+}
 
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		if !field.CanSet() {
-			continue // Skip unexported fields
+// FormatDefaultsMarkdown returns the flags of config as a GitHub-flavored
+// Markdown table with the columns Flag, Short, Type, Default and Description,
+// in the order PrintDefaults lists them, for embedding in documentation.
+func FormatDefaultsMarkdown(config interface{}) string {
+	var b strings.Builder
+	b.WriteString("| Flag | Short | Type | Default | Description |\n")
+	b.WriteString("|---|---|---|---|---|\n")
+	escape := strings.NewReplacer("|", "\\|", "\n", " ")
+	for _, info := range Describe(config) {
+		var shorts []string
+		for _, short := range append([]string{info.Short}, info.Aliases...) {
+			if short != "" {
+				shorts = append(shorts, "`-"+short+"`")
+			}
 		}
-		fieldType := t.Field(i)
-		defaultValue := fieldType.Tag.Get("default")
-		if defaultValue == "" {
-			continue
+		def := ""
+		if info.Default != "" {
+			def = "`" + escape.Replace(info.Default) + "`"
 		}
+		fmt.Fprintf(&b, "| `--%s` | %s | %s | %s | %s |\n",
+			info.Name, strings.Join(shorts, ", "), escape.Replace(info.Type), def, escape.Replace(info.Usage))
+	}
+	return b.String()
+}
 
-		err := SetField(field, defaultValue, false)
-		if err != nil {
-			return fmt.Errorf("error setting default for field %s: %v", fieldType.Name, err)
+// sortFlags lists flags alphabetically in the help output.
+var sortFlags = false
+
+// SetSortFlags sets whether PrintDefaults and Describe list flags
+// alphabetically by long name instead of in declaration order. Flags with an
+// order tag still come first. Disabled by default.
+func SetSortFlags(enabled bool) {
+	sortFlags = enabled
+}
+
+// sortByOrder sorts fields by their order tag, lowest first. Fields without
+// an order tag come last, and ties keep declaration order, or with
+// SetSortFlags are sorted by long name.
+func sortByOrder(fields []flagTarget) {
+	order := func(sf flagTarget) int {
+		if n, err := strconv.Atoi(sf.Tag.Get("order")); err == nil {
+			return n
 		}
+		return math.MaxInt
 	}
-	return nil
+	sort.SliceStable(fields, func(i, j int) bool {
+		oi, oj := order(fields[i]), order(fields[j])
+		if oi == oj && sortFlags {
+			return fields[i].keys[0] < fields[j].keys[0]
+		}
+		return oi < oj
+	})
 }
 
-// Parse parses the CLI arguments and populates the config struct.
-func SetFlags(config interface{}, flags map[string]string) error {
-	v := reflect.ValueOf(config)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+func describe(fields []flagTarget) []FlagInfo {
+	infos := make([]FlagInfo, len(fields))
+	for i, sf := range fields {
+		var short string
+		var aliases []string
+		if names := sf.keys[1:]; len(names) > 0 {
+			short = names[0]
+			if len(names) > 1 {
+				aliases = names[1:]
+			}
+		}
+		infos[i] = FlagInfo{
+			Name:     sf.keys[0],
+			Short:    short,
+			Aliases:  aliases,
+			Type:     typeName(sf.Type),
+			Usage:    sf.Tag.Get("usage"),
+			Group:    sf.Tag.Get("group"),
+			Required: sf.Tag.Get("required") == "true",
+		}
+		if sf.Tag.Get("secret") != "true" {
+			infos[i].Default = defaultHint(sf.StructField)
+		}
 	}
-	if v.Kind() != reflect.Struct {
-		return errors.New("config must be a pointer to a struct")
+	return infos
+}
+
+// defaultHint returns the default of field as shown in the help output, or ""
+// when the default is the zero value of the field type, such as "0" for an int
+// or "false" for a bool. Defaults are formatted with the fmt tag if set, and
+// defaults of types implementing fmt.Stringer are shown through String.
+// Defaults that fail to parse are shown literally.
+func defaultHint(field reflect.StructField) string {
+	def := field.Tag.Get("default")
+	if def == "" {
+		return ""
 	}
-	t := v.Type()
+	value := reflect.New(field.Type).Elem()
+	if err := setField(value, field.Tag, def, false); err != nil {
+		return def
+	}
+	if value.IsZero() {
+		return ""
+	}
+	if format := field.Tag.Get("fmt"); format != "" {
+		return displayValue(format, value)
+	}
+	if s, ok := stringValue(value); ok {
+		return s
+	}
+	return def
+}
+
+// displayValue formats value, or the value it points to, with the fmt tag
+// format, as in fmt:"%.2f".
+func displayValue(format string, value reflect.Value) string {
+	if value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	return fmt.Sprintf(format, value.Interface())
+}
 
-	for i := 0; i < v.NumField(); i++ {
-		var err error
-		field := v.Field(i)
-		fieldType := t.Field(i)
-		shortName := fieldType.Tag.Get("short")
-		flagName := fieldType.Tag.Get("flag")
-		if flagName == "" {
-			flagName = words.ToKebabCase(fieldType.Name)
+// stringValue returns the String method result of a value whose type, or for
+// an addressable value its pointer type, implements fmt.Stringer.
+func stringValue(value reflect.Value) (string, bool) {
+	if value.Kind() == reflect.Ptr && value.IsNil() {
+		return "", false
+	}
+	if stringer, ok := value.Interface().(fmt.Stringer); ok {
+		return stringer.String(), true
+	}
+	if !value.CanAddr() {
+		return "", false
+	}
+	if stringer, ok := value.Addr().Interface().(fmt.Stringer); ok {
+		return stringer.String(), true
+	}
+	return "", false
+}
+
+// typeName returns the name of t as shown in the help output.
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + typeName(t.Elem())
+	case reflect.Slice:
+		if t.Name() == "" {
+			return "[]" + typeName(t.Elem())
 		}
-		if flagValue, exists := flags[shortName]; exists {
-			err = SetField(field, flagValue, true)
-		} else if flagValue, exists := flags[flagName]; exists {
-			err = SetField(field, flagValue, true)
+	}
+	return t.Name()
+}
+
+// helpEntry is a single line of the PrintDefaults output.
+type helpEntry struct {
+	short    string
+	name     string
+	usage    string
+	group    string
+	required bool
+	def      string // Default hint within usage, dimmed when colored
+}
+
+// helpSection is a titled list of help entries.
+type helpSection struct {
+	name    string
+	entries []helpEntry
+}
+
+// groupEntries buckets entries by their group tag, keeping declaration order
+// within a group and ordering groups by first appearance. Ungrouped entries
+// go under "Options". When no entry has a group but some are required, they
+// are split into "Required flags" and "Optional flags" instead. Otherwise a
+// single untitled section is returned.
+func groupEntries(entries []helpEntry) []helpSection {
+	grouped, required := false, false
+	for _, e := range entries {
+		grouped = grouped || e.group != ""
+		required = required || e.required
+	}
+	if required && !grouped {
+		sections := []helpSection{{name: message("required_header")}, {name: message("optional_header")}}
+		for _, e := range entries {
+			if e.required {
+				sections[0].entries = append(sections[0].entries, e)
+			} else {
+				sections[1].entries = append(sections[1].entries, e)
+			}
 		}
-		if err != nil {
-			// PrintDefaults(config) // Print help message
-			return fmt.Errorf("error parsing flag --%s: %v", flagName, err)
+		if len(sections[1].entries) == 0 {
+			return sections[:1]
 		}
+		return sections
 	}
 
-	return nil
+	var sections []helpSection
+	index := make(map[string]int)
+	for _, e := range entries {
+		i, exists := index[e.group]
+		if !exists {
+			i = len(sections)
+			index[e.group] = i
+			name := message("options_header")
+			if e.group != "" {
+				name = fmt.Sprintf(message("group_header"), e.group)
+			}
+			sections = append(sections, helpSection{name: name})
+		}
+		sections[i].entries = append(sections[i].entries, e)
+	}
+	if _, ungrouped := index[""]; ungrouped && len(sections) == 1 {
+		sections[0].name = ""
+	}
+	return sections
 }
 
-// SetField sets the field based on its type and the string value provided.
-func SetField(field reflect.Value, value string, exists bool) error {
-	switch field.Kind() {
-	case reflect.String:
-		field.SetString(value)
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		intValue, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			return err
+// helpWidth is the width PrintDefaults wraps usage descriptions at, once set
+// with SetHelpWidth. Until then the width is read from $COLUMNS.
+var (
+	helpWidth    = 0
+	helpWidthSet = false
+)
+
+// SetHelpWidth sets the width PrintDefaults wraps usage descriptions at.
+// A zero or negative width disables wrapping.
+func SetHelpWidth(width int) {
+	helpWidth, helpWidthSet = width, true
+}
+
+// terminalWidth returns the terminal width from $COLUMNS, looked up with
+// lookupEnv, defaulting to 80.
+func terminalWidth(lookupEnv func(string) (string, bool)) int {
+	columns, _ := lookupEnv("COLUMNS")
+	if width, err := strconv.Atoi(columns); err == nil && width > 0 {
+		return width
+	}
+	return 80
+}
+
+// wrapText breaks s into lines of at most width runes at word boundaries.
+// Words longer than width are kept on a line of their own.
+func wrapText(s string, width int) []string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return []string{s}
+	}
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+			lines = append(lines, line)
+			line = ""
 		}
-		field.SetInt(intValue)
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		uintValue, err := strconv.ParseUint(value, 10, 64)
-		if err != nil {
-			return err
+		if line != "" {
+			line += " "
 		}
-		field.SetUint(uintValue)
-	case reflect.Bool:
-		if exists && value == "" {
-			field.SetBool(true)
-			return nil
+		line += word
+	}
+	return append(lines, line)
+}
+
+// structField is an exported field of a config struct together with its value.
+type structField struct {
+	reflect.StructField
+	Value reflect.Value
+}
+
+// structFields returns the exported fields of the struct v in declaration order.
+// The fields of embedded structs are promoted into the parent without a prefix,
+// with outer fields shadowing embedded fields of the same name as in Go.
+// Fields tagged flag:"-" are left out.
+func structFields(v reflect.Value) []structField {
+	var fields []structField
+	for _, field := range reflect.VisibleFields(v.Type()) {
+		if !field.IsExported() || isEmbeddedStruct(field) || field.Tag.Get("flag") == "-" {
+			continue
 		}
-		boolValue, err := strconv.ParseBool(value)
+		value, err := v.FieldByIndexErr(field.Index)
 		if err != nil {
-			return err
+			continue // Promoted through a nil embedded pointer
 		}
-		field.SetBool(boolValue)
-	case reflect.Float32, reflect.Float64:
-		floatValue, err := strconv.ParseFloat(value, 64)
-		if err != nil {
-			return err
+		fields = append(fields, structField{field, value})
+	}
+	if autoShort {
+		assignShorts(fields)
+	}
+	return fields
+}
+
+// autoShort gives flags without a short tag a short name.
+var autoShort = false
+
+// SetAutoShort sets whether flags without a short tag get the first letter of
+// their field name as short name, lowercased. When that letter is taken by an
+// earlier field or a short tag, the next letter of the name is tried, so with
+// Port and Path fields Path gets -a. A field whose letters are all taken gets
+// no short name, and an empty short:"" tag opts a field out. While SetAutoHelp
+// is enabled, h is kept for -h. Disabled by default.
+func SetAutoShort(enabled bool) {
+	autoShort = enabled
+}
+
+// assignShorts adds a short tag to the flag fields without one.
+func assignShorts(fields []structField) {
+	claimed := map[string]bool{"h": autoHelp}
+	for _, sf := range fields {
+		for _, name := range shortNames(sf.StructField) {
+			claimed[name] = true
 		}
-		field.SetFloat(floatValue)
-	case reflect.Slice:
-		// Assumes comma-separated values for slice types
-		elemType := field.Type().Elem()
-		if elemType.Kind() == reflect.String {
-			field.Set(reflect.ValueOf(strings.Split(value, ",")))
-		} else {
-			// More complex parsing required for non-string slices
-			return errors.New("complex slice types are not supported yet")
+	}
+	for i, sf := range fields {
+		_, tagged := sf.Tag.Lookup("short")
+		if tagged || isNestedStruct(sf) || !allowsSource(sf.StructField, "flag") {
+			continue
 		}
-	default:
-		if field.Type().Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()) {
-			// Handle types that implement encoding.TextUnmarshaler
-			unmarshaler := reflect.New(field.Type().Elem()).Interface().(encoding.TextUnmarshaler)
-			if err := unmarshaler.UnmarshalText([]byte(value)); err != nil {
+		for _, r := range strings.ToLower(sf.Name) {
+			name := string(r)
+			if unicode.IsLetter(r) && !claimed[name] {
+				claimed[name] = true
+				fields[i].Tag = reflect.StructTag(strings.TrimSpace(string(sf.Tag) + ` short:"` + name + `"`))
+				break
+			}
+		}
+	}
+}
+
+// WalkFields calls fn for every field of config that the other functions
+// handle, in declaration order, with the field's value, its tags and its long
+// flag name as path. The fields of embedded structs are promoted as usual, and
+// nested structs are descended into, with paths like log.level. Unexported
+// fields and fields tagged flag:"-" are skipped. An error returned by fn stops
+// the walk and is returned. This allows custom processing, such as bulk
+// validation, without reimplementing the reflection. Validate, MarshalArgs,
+// ExportEnv, positional arguments and ${name} references build on it.
+func WalkFields(config interface{}, fn func(field reflect.Value, tag reflect.StructTag, path string) error) error {
+	v := reflect.Indirect(reflect.ValueOf(config))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a struct or a pointer to a struct, got %T", config)
+	}
+	return walkFields(v, "", func(sf structField, path string) error {
+		return fn(sf.Value, sf.Tag, path)
+	})
+}
+
+// walkFields is WalkFields for the struct v, with prefix before the paths.
+func walkFields(v reflect.Value, prefix string, fn func(sf structField, path string) error) error {
+	for _, sf := range structFields(v) {
+		path := prefix + flagName(sf.StructField)
+		if isNestedStruct(sf) {
+			if err := walkFields(sf.Value, path+".", fn); err != nil {
 				return err
 			}
-			field.Set(reflect.ValueOf(unmarshaler).Elem())
-		} else {
-			return errors.New("unsupported flag type")
+			continue
+		}
+		if err := fn(sf, path); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// ParseEnv parses environment variables and populates the config struct.
-func ParseEnv(config interface{}) error {
+// isEmbeddedStruct reports whether field is an embedded struct or struct pointer.
+func isEmbeddedStruct(field reflect.StructField) bool {
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return field.Anonymous && t.Kind() == reflect.Struct
+}
+
+// configStruct returns the struct config points to. A struct passed by value
+// is rejected, as setting its fields would only change a copy.
+func configStruct(config interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("config must be a pointer to a struct, got %T", config)
+	}
+	return v.Elem(), nil
+}
+
+// allowsSource reports whether field can be set from source, "env" or
+// "flag". A field tagged source:"env" is only read from the environment and
+// one tagged source:"flag" only from flags.
+func allowsSource(field reflect.StructField, source string) bool {
+	if source == "flag" && field.Tag.Get("arg") != "" {
+		return false // Bound to a positional argument instead
+	}
+	if field.Tag.Get("rest") == "true" {
+		return false // Only bound to the arguments after --
+	}
+	only := field.Tag.Get("source")
+	return only == "" || only == source
+}
+
+// flagName returns the long flag name of field: its flag tag, or the
+// kebab-cased field name.
+func flagName(field reflect.StructField) string {
+	if name := field.Tag.Get("flag"); name != "" {
+		return name
+	}
+	return flagNameFunc(field.Name)
+}
+
+// flagNameFunc derives long flag names from field names.
+var flagNameFunc = words.ToKebabCase
+
+// SetFlagNameFunc sets the function deriving long flag names from field names
+// for fields without a flag tag. It is used for both parsing and help, so the
+// names always match. The default produces port-number from PortNumber. A nil
+// function restores the default.
+func SetFlagNameFunc(fn func(fieldName string) string) {
+	if fn == nil {
+		fn = words.ToKebabCase
+	}
+	flagNameFunc = fn
+}
+
+// shortNames returns the short flag names of field. A short tag can list
+// several single-letter aliases separated by commas, as in short:"v,d".
+func shortNames(field reflect.StructField) []string {
+	tag := field.Tag.Get("short")
+	if tag == "" {
+		return nil
+	}
+	names := strings.Split(tag, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+	}
+	return names
+}
+
+// envName returns the environment variable name of field: its env tag, or the
+// constant-cased field name.
+func envName(field reflect.StructField) string {
+	if name := field.Tag.Get("env"); name != "" {
+		return name
+	}
+	return envNameFunc(field.Name)
+}
+
+// envNameFunc derives environment variable names from field names.
+var envNameFunc = words.ToConstantCase
+
+// SetEnvNameFunc sets the function deriving environment variable names from
+// field names for fields without an env tag. The default produces PORT_NUMBER
+// from PortNumber. A nil function restores the default.
+func SetEnvNameFunc(fn func(fieldName string) string) {
+	if fn == nil {
+		fn = words.ToConstantCase
+	}
+	envNameFunc = fn
+}
+
+// ValidateNames checks that no two fields of the config share a long flag
+// name, short flag name or environment variable name, including names derived
+// from field names. It returns an error naming every duplicate. Flag names are
+// only compared among fields that flags set and environment variable names
+// among fields read from the environment, as limited by the source tag.
+func ValidateNames(config interface{}) error {
 	v := reflect.ValueOf(config)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
@@ -202,51 +686,1802 @@ func ParseEnv(config interface{}) error {
 	if v.Kind() != reflect.Struct {
 		return errors.New("config must be a pointer to a struct")
 	}
-	t := v.Type()
 
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		fieldType := t.Field(i)
-		envName := fieldType.Tag.Get("env")
-		if envName == "" {
-			envName = words.ToConstantCase(fieldType.Name)
+	// Long and short names are compared without dashes, as --p and -p set the
+	// same key.
+	var duplicates []string
+	seen := make(map[string]string)
+	check := func(kind, name, display, field string) {
+		key := kind + " " + name
+		if other, exists := seen[key]; exists {
+			duplicates = append(duplicates, fmt.Sprintf("duplicate %s %s for fields %s and %s", kind, display, other, field))
+			return
 		}
+		seen[key] = field
+	}
+	// Each field is only checked in the namespaces it is read from
+	for _, sf := range structFields(v) {
+		if allowsSource(sf.StructField, "flag") {
+			name := flagName(sf.StructField)
+			check("flag", name, "--"+name, sf.Name)
+			for _, shortName := range shortNames(sf.StructField) {
+				check("flag", shortName, "-"+shortName, sf.Name)
+			}
+		}
+		if allowsSource(sf.StructField, "env") && !isNestedStruct(sf) {
+			check("environment variable", envName(sf.StructField), envName(sf.StructField), sf.Name)
+		}
+	}
+	if len(duplicates) > 0 {
+		return errors.New(strings.Join(duplicates, "; "))
+	}
+	return nil
+}
 
-		envValue, exists := os.LookupEnv(envName)
-		if !exists {
-			continue // If environment variable is not set, skip setting the field
+// SetDefaults sets default values for fields in the config struct based on struct tags.
+func SetDefaults(config interface{}) error {
+	return setDefaults(config, os.LookupEnv, nil)
+}
+
+func setDefaults(config interface{}, lookupEnv func(string) (string, bool), sources Sources) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+	return setStructDefaults(v, "", lookupEnv, sources)
+}
+
+// setStructDefaults applies the default tags of the fields of v, descending
+// into nested structs. Field names in errors and sources get pathPrefix, like
+// Log. for the fields of a nested Log struct.
+func setStructDefaults(v reflect.Value, pathPrefix string, lookupEnv func(string) (string, bool), sources Sources) error {
+	for _, sf := range structFields(v) {
+		field := sf.Value
+		if !field.CanSet() {
+			continue // Skip unexported fields
+		}
+		fieldType := sf.StructField
+		if isNestedStruct(sf) {
+			if err := setStructDefaults(field, pathPrefix+fieldType.Name+".", lookupEnv, sources); err != nil {
+				return err
+			}
+			continue
+		}
+		defaultValue := defaultString(fieldType, lookupEnv)
+		if defaultValue == "" {
+			continue
 		}
 
-		err := SetField(field, envValue, true)
+		err := setField(field, fieldType.Tag, defaultValue, false)
 		if err != nil {
-			// PrintDefaults(config) // Print help message if there's an error setting the field
-			return fmt.Errorf("error setting environment variable %s: %v", envName, err)
+			return fmt.Errorf("error setting default for field %s: %v", pathPrefix+fieldType.Name, fieldError(err, fieldType))
 		}
+		sources.set(pathPrefix+fieldType.Name, SourceDefault)
 	}
-
 	return nil
 }
 
-// SetAll configures the application settings by setting defaults, parsing environment variables,
-// and command-line arguments. It also checks for help flags (--help, -h) to display help messages.
-func ParseAll(config interface{}, args []string) ([]string, map[string]string, error) {
-	if err := SetDefaults(config); err != nil {
-		return nil, nil, fmt.Errorf("error setting default values: %v", err)
+// defaultString returns the default of field: its default tag, or the value
+// of the variable named by its defaultEnv tag, expanded with expand:"true".
+// Variables are looked up with lookupEnv.
+func defaultString(field reflect.StructField, lookupEnv func(string) (string, bool)) string {
+	value := field.Tag.Get("default")
+	if name := field.Tag.Get("defaultEnv"); value == "" && name != "" {
+		value, _ = lookupEnv(name)
 	}
-	if err := ParseEnv(config); err != nil {
-		return nil, nil, fmt.Errorf("error parsing environment variables: %v", err)
+	if field.Tag.Get("expand") == "true" {
+		value = os.Expand(value, func(name string) string {
+			v, _ := lookupEnv(name)
+			return v
+		})
 	}
-	for _, arg := range args {
-		if arg == "--help" || arg == "-h" {
-			fmt.Println("Usage:")
-			PrintDefaults(config)
-			return nil, nil, nil
+	return value
+}
+
+// Reset sets every field of config to its zero value and then applies the
+// default tags, undoing the values of an earlier parse. Fields of embedded
+// structs are reset like other fields, and fields skipped by the other
+// functions, such as unexported fields, are left alone.
+func Reset(config interface{}) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+	for _, sf := range structFields(v) {
+		if sf.Value.CanSet() {
+			sf.Value.Set(reflect.Zero(sf.Type))
 		}
 	}
-	outArgs, flags := ParseArgs(args)
-	err := SetFlags(config, flags)
+	return setDefaults(config, os.LookupEnv, nil)
+}
+
+// SetDefaultsFrom is like SetDefaults but then copies the non-zero fields of
+// defaults into config, so they take precedence over default tags. Fields are
+// matched by name and must have the same type. This allows defaults that can't
+// be written as a tag, such as a slice of structs or a value computed at
+// runtime. Call ParseEnv and SetFlags afterwards to apply the other sources.
+// The types are checked first, so on a mismatch config is left unchanged.
+// For ParseAll, implement DefaultsProvider instead.
+func SetDefaultsFrom(config interface{}, defaults interface{}) error {
+	return setDefaultsFrom(config, defaults, os.LookupEnv, nil)
+}
+
+// DefaultsProvider is implemented by configs with defaults that can't be
+// written as a tag. ParseAll copies the non-zero fields of the struct Defaults
+// returns over the default tags, as SetDefaultsFrom does, so environment
+// variables and flags still override them.
+type DefaultsProvider interface {
+	Defaults() interface{}
+}
+
+func setDefaultsFrom(config interface{}, defaults interface{}, lookupEnv func(string) (string, bool), sources Sources) error {
+	v, err := configStruct(config)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error parsing command-line arguments: %v", err)
+		return err
+	}
+	d := reflect.Indirect(reflect.ValueOf(defaults))
+	if d.Kind() != reflect.Struct {
+		return errors.New("defaults must be a struct or a pointer to a struct")
+	}
+
+	byName := make(map[string]reflect.Value)
+	for _, sf := range structFields(d) {
+		byName[sf.Name] = sf.Value
+	}
+	var targets []structField
+	var values []reflect.Value
+	for _, sf := range structFields(v) {
+		value, ok := byName[sf.Name]
+		if !ok || value.IsZero() {
+			continue
+		}
+		if value.Type() != sf.Type {
+			return fmt.Errorf("default for field %s has type %s, expected %s", sf.Name, value.Type(), sf.Type)
+		}
+		targets = append(targets, sf)
+		values = append(values, value)
+	}
+
+	if err := setDefaults(config, lookupEnv, sources); err != nil {
+		return err
+	}
+	for i, target := range targets {
+		target.Value.Set(copyValue(values[i]))
+		sources.set(target.Name, SourceDefault)
+	}
+	return nil
+}
+
+// copyValue returns a copy of v that doesn't share the backing array of a
+// slice or the entries of a map, so later flags don't change the defaults.
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		return reflect.AppendSlice(reflect.MakeSlice(v.Type(), 0, v.Len()), v)
+	case reflect.Map:
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			m.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return m
 	}
-	return outArgs, flags, nil
+	return v
+}
+
+// Parse parses the CLI arguments and populates the config struct.
+func SetFlags(config interface{}, flags map[string]string) error {
+	return setFlags(config, flagsFromMap(flags), nil)
+}
+
+// SetFlagsOrdered is like SetFlags but applies the flags in order. When a flag
+// is repeated the last value wins, except for slice fields, which collect the
+// values of every occurrence, and map fields, which merge the entries. Slice
+// fields tagged append:"true" also keep the values they had before the flags.
+func SetFlagsOrdered(config interface{}, flags Flags) error {
+	return setFlags(config, flags, nil)
+}
+
+// SetFlagsSubset is like SetFlags but only sets the fields listed in only, by
+// field name or long flag name, leaving the other fields untouched. Flags for
+// unlisted fields are ignored. This allows binding the sections of a config
+// at different times.
+func SetFlagsSubset(config interface{}, flags map[string]string, only []string) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]bool)
+	for _, sf := range structFields(v) {
+		if !slices.Contains(only, sf.Name) && !slices.Contains(only, flagName(sf.StructField)) {
+			continue
+		}
+		keys[flagName(sf.StructField)] = true
+		for _, shortName := range shortNames(sf.StructField) {
+			keys[shortName] = true
+		}
+	}
+	subset := make(map[string]string)
+	for key, value := range flags {
+		if keys[key] {
+			subset[key] = value
+		}
+	}
+	return SetFlags(config, subset)
+}
+
+// ParseFlagsFile sets the fields of config from a file of flags, one per line
+// as name=value, where name is a long or short flag name as SetFlags matches
+// it. A line with just a name is a flag without a value. Blank lines and lines
+// starting with # are ignored. Call it between ParseEnv and SetFlags so the
+// file overrides environment variables and command-line flags override the
+// file. Errors name the line of the file, and config is left unchanged when
+// any line is invalid.
+func ParseFlagsFile(config interface{}, path string) error {
+	if _, err := configStruct(config); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var flags Flags
+	var lineNumbers []int
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, hasValue := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		flag := Flag{Key: key, Value: strings.TrimSpace(value), HasValue: hasValue, Token: "--" + key}
+		if utf8.RuneCountInString(key) == 1 {
+			flag.Token, flag.Pos = "-"+key, 1 // Reported as a short flag
+		}
+		flags = append(flags, flag)
+		lineNumbers = append(lineNumbers, i+1)
+	}
+
+	// Each line is first checked on its own against a scratch config, so an
+	// error can name its line.
+	scratch := reflect.New(reflect.TypeOf(config).Elem()).Interface()
+	for i := range flags {
+		line := flags[i : i+1]
+		if strict {
+			if err := checkUnknown(config, line); err != nil {
+				return fmt.Errorf("error parsing flags file %s, line %d: %v", path, lineNumbers[i], err)
+			}
+		}
+		if err := setFlags(scratch, line, nil); err != nil {
+			return fmt.Errorf("error parsing flags file %s, line %d: %v", path, lineNumbers[i], err)
+		}
+	}
+	if err := setFlags(config, flags, nil); err != nil {
+		return fmt.Errorf("error parsing flags file %s: %v", path, err)
+	}
+	return nil
+}
+
+func setFlags(config interface{}, flags Flags, sources Sources) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+
+	fields := structFields(v)
+	if allowAbbrev {
+		if flags, err = expandAbbrev(fields, flags); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range flagTargets(fields, "", "") {
+		if skipField(sources, target.path) {
+			continue
+		}
+		field := target.Value
+		fieldType := target.StructField
+		keys := target.keys
+		flagName := keys[0]
+		matched := false
+		for _, flag := range flags {
+			if !slices.Contains(keys, flag.Key) && !slices.Contains(keys, strings.ReplaceAll(flag.Key, "/", ".")) {
+				continue
+			}
+			value := flag.Value
+			if implicit := fieldType.Tag.Get("implicit"); implicit != "" && !flag.HasValue {
+				value = implicit
+			}
+			var err error
+			appending := matched || fieldType.Tag.Get("append") == "true"
+			if appending && isListType(field.Type()) {
+				// Repeated slice flags collect their values
+				values := reflect.New(field.Type()).Elem()
+				err = setField(values, fieldType.Tag, value, true)
+				field.Set(reflect.AppendSlice(field, values))
+				if fieldType.Tag.Get("unique") == "true" {
+					field.Set(uniqueSlice(field))
+				}
+			} else if matched && field.Kind() == reflect.Map {
+				// Repeated map flags merge their entries
+				entries := reflect.New(field.Type()).Elem()
+				err = setField(entries, fieldType.Tag, value, true)
+				for iter := entries.MapRange(); iter.Next(); {
+					field.SetMapIndex(iter.Key(), iter.Value())
+				}
+			} else {
+				err = setField(field, fieldType.Tag, value, true)
+			}
+			if err != nil {
+				// PrintDefaults(config) // Print help message
+				return fmt.Errorf("error parsing flag --%s: %v", flagName, fieldError(err, fieldType))
+			}
+			matched = true
+		}
+		if matched {
+			sources.set(target.path, SourceFlag)
+		}
+	}
+
+	return nil
+}
+
+// flagTarget is a field that flags can set, with the keys that match it and
+// its path of field names for Sources.
+type flagTarget struct {
+	structField
+	keys []string
+	path string
+}
+
+// flagTargets returns the fields that flags can set. Nested structs are
+// descended into, and their fields are matched by their dotted path of long
+// names, like log.level, without short names.
+func flagTargets(fields []structField, prefix, pathPrefix string) []flagTarget {
+	var targets []flagTarget
+	for _, sf := range fields {
+		if !allowsSource(sf.StructField, "flag") {
+			continue
+		}
+		name := prefix + flagName(sf.StructField)
+		if isNestedStruct(sf) {
+			targets = append(targets, flagTargets(structFields(sf.Value), name+".", pathPrefix+sf.Name+".")...)
+			continue
+		}
+		keys := []string{name}
+		if prefix == "" {
+			keys = append(keys, shortNames(sf.StructField)...)
+		}
+		targets = append(targets, flagTarget{sf, keys, pathPrefix + sf.Name})
+	}
+	return targets
+}
+
+// isNestedStruct reports whether sf is a nested config rather than a struct
+// parsed from a single value.
+func isNestedStruct(sf structField) bool {
+	return sf.Type.Kind() == reflect.Struct && !isValueStruct(sf.Type) && sf.Tag.Get("as") != "json"
+}
+
+// isListType reports whether t is a slice that collects the values of
+// repeated flags. Slices parsed from a single value, such as net.IP, []byte
+// and types with a registered parser, Set or UnmarshalText method, are not:
+// for those the last flag wins.
+func isListType(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice || t == ipType || t.Elem().Kind() == reflect.Uint8 {
+		return false
+	}
+	if _, ok := lookupParser(t); ok {
+		return false
+	}
+	ptr := reflect.PointerTo(t)
+	return !ptr.Implements(textUnmarshalerType) && !ptr.Implements(valueSetterType)
+}
+
+// nestedField returns the field of a nested struct that a key like log.level
+// or feature/x refers to, descending into one struct field per segment, and
+// its path of field names like Log.Level.
+func nestedField(fields []structField, key string) (structField, string, bool) {
+	i := strings.IndexAny(key, "./")
+	if i < 0 {
+		return structField{}, "", false
+	}
+	head, rest := key[:i], key[i+1:]
+	for _, sf := range fields {
+		if flagName(sf.StructField) != head || sf.Type.Kind() != reflect.Struct || !allowsSource(sf.StructField, "flag") {
+			continue
+		}
+		nested := structFields(sf.Value)
+		if found, path, ok := nestedField(nested, rest); ok {
+			return found, sf.Name + "." + path, true
+		}
+		for _, field := range nested {
+			if flagName(field.StructField) == rest && allowsSource(field.StructField, "flag") {
+				return field, sf.Name + "." + field.Name, true
+			}
+		}
+	}
+	return structField{}, "", false
+}
+
+// allowAbbrev enables matching long flags by an unambiguous prefix.
+var allowAbbrev = false
+
+// SetAllowAbbrev sets whether a long flag may be abbreviated to a prefix that
+// matches exactly one long flag name, so --verb sets --verbose. A prefix
+// matching several names is an error. Exact names always match. Disabled by
+// default.
+func SetAllowAbbrev(allow bool) {
+	allowAbbrev = allow
+}
+
+// expandAbbrev replaces abbreviated long flags with the long flag names of
+// the fields they match. Fields that flags can't set are not candidates.
+func expandAbbrev(fields []structField, flags Flags) (Flags, error) {
+	var names []string
+	for _, sf := range fields {
+		if allowsSource(sf.StructField, "flag") {
+			names = append(names, flagName(sf.StructField))
+		}
+	}
+
+	expanded := make(Flags, len(flags))
+	for i, flag := range flags {
+		expanded[i] = flag
+		if utf8.RuneCountInString(flag.Key) < 2 {
+			continue // Short flags are never abbreviations
+		}
+		matches := abbrevMatches(names, flag.Key)
+		switch {
+		case len(matches) == 1:
+			expanded[i].Key = matches[0]
+		case len(matches) > 1:
+			return nil, fmt.Errorf("--%s is ambiguous: --%s", flag.Key, strings.Join(matches, ", --"))
+		}
+	}
+	return expanded, nil
+}
+
+// abbrevMatches returns the names key is a prefix of, or only key itself
+// when it is one of the names.
+func abbrevMatches(names []string, key string) []string {
+	var matches []string
+	for _, name := range names {
+		if name == key {
+			return []string{name}
+		}
+		if strings.HasPrefix(name, key) {
+			matches = append(matches, name)
+		}
+	}
+	return matches
+}
+
+// isKnownFlag returns a function reporting whether a flag key matches one of
+// the fields, by long name, short name or, with SetAllowAbbrev, a prefix.
+func isKnownFlag(fields []structField) func(key string) bool {
+	known := make(map[string]bool)
+	var names []string
+	for _, sf := range fields {
+		if !allowsSource(sf.StructField, "flag") {
+			continue
+		}
+		known[flagName(sf.StructField)] = true
+		names = append(names, flagName(sf.StructField))
+		for _, shortName := range shortNames(sf.StructField) {
+			known[shortName] = true
+		}
+	}
+	return func(key string) bool {
+		if _, _, ok := nestedField(fields, key); ok {
+			return true
+		}
+		return known[key] || allowAbbrev && utf8.RuneCountInString(key) > 1 && len(abbrevMatches(names, key)) == 1
+	}
+}
+
+// strict makes ParseAll reject flags that don't match any field.
+var strict = false
+
+// SetStrict sets whether ParseAll returns an error for flags that don't match
+// any field, instead of ignoring them. Disabled by default.
+func SetStrict(enabled bool) {
+	strict = enabled
+}
+
+// checkUnknown returns an error for the first flag that doesn't match any
+// field. For a flag from a cluster of short flags the error points at its
+// letter, as in: unknown flag -x at position 3 in "-abx".
+func checkUnknown(config interface{}, flags Flags) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+	known := isKnownFlag(structFields(v))
+	for _, flag := range flags {
+		if known(flag.Key) || flag.Key == configDumpFlag {
+			continue
+		}
+		if flag.Pos == 0 {
+			return fmt.Errorf("unknown flag --%s", flag.Key)
+		}
+		if utf8.RuneCountInString(flag.Token) > 2 {
+			return fmt.Errorf("unknown flag -%s at position %d in %q", flag.Key, flag.Pos, flag.Token)
+		}
+		return fmt.Errorf("unknown flag -%s", flag.Key)
+	}
+	return nil
+}
+
+// SetFlagsPassthrough is like SetFlags but returns the flags that don't match
+// any field, reconstructed as arguments that can be forwarded to another
+// program. Long flags are returned as --key=value or --key, short flags as
+// -k value or -k. The flags are sorted by name.
+func SetFlagsPassthrough(config interface{}, flags map[string]string) ([]string, error) {
+	if err := SetFlags(config, flags); err != nil {
+		return nil, err
+	}
+	known := isKnownFlag(structFields(reflect.Indirect(reflect.ValueOf(config))))
+	var keys []string
+	for key := range flags {
+		if !known(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	unknown := []string{}
+	for _, key := range keys {
+		unknown = append(unknown, formatFlag(key, flags[key])...)
+	}
+	return unknown, nil
+}
+
+// formatFlag returns the arguments that ParseArgs parses back into key=value.
+func formatFlag(key, value string) []string {
+	switch {
+	case utf8.RuneCountInString(key) > 1 && value == "":
+		return []string{"--" + key}
+	case utf8.RuneCountInString(key) > 1:
+		return []string{"--" + key + "=" + value}
+	case value == "":
+		return []string{"-" + key}
+	case strings.HasPrefix(value, "-"):
+		return []string{"-" + key + "=" + value}
+	}
+	return []string{"-" + key, value}
+}
+
+// SetField sets the field based on its type and the string value provided.
+func SetField(field reflect.Value, value string, exists bool) error {
+	return setField(field, "", value, exists)
+}
+
+// parsers holds the parsers added with RegisterParser, guarded by parsersMu
+// as they may be registered while another goroutine parses.
+var (
+	parsersMu sync.RWMutex
+	parsers   = make(map[reflect.Type]func(s string) (interface{}, error))
+)
+
+// RegisterParser registers fn to parse values of type t, taking precedence
+// over the built-in parsing. The value fn returns must be assignable to t.
+// This allows parsing types that can't implement encoding.TextUnmarshaler,
+// such as types from other packages. The returned function unregisters fn,
+// restoring the parser t had before, if any.
+func RegisterParser(t reflect.Type, fn func(s string) (interface{}, error)) (unregister func()) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	previous, existed := parsers[t]
+	parsers[t] = fn
+	return func() {
+		parsersMu.Lock()
+		defer parsersMu.Unlock()
+		if existed {
+			parsers[t] = previous
+		} else {
+			delete(parsers, t)
+		}
+	}
+}
+
+// lookupParser returns the parser registered for t.
+func lookupParser(t reflect.Type) (func(s string) (interface{}, error), bool) {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	parse, ok := parsers[t]
+	return parse, ok
+}
+
+// GetFlag looks up name in a flags map, as returned by ParseArgs, and parses
+// its value into T the way SetField would. It returns false when the flag is
+// absent, and an error when its value can't be parsed.
+func GetFlag[T any](flags map[string]string, name string) (T, bool, error) {
+	var value T
+	raw, ok := flags[name]
+	if !ok {
+		return value, false, nil
+	}
+	if err := SetField(reflect.ValueOf(&value).Elem(), raw, true); err != nil {
+		return value, true, fmt.Errorf("error parsing flag --%s: %v", name, err)
+	}
+	return value, true, nil
+}
+
+// ParseInto parses args like ParseArgs and stores the flags in m, for tools
+// without a config struct. It returns the positional arguments.
+//
+// When m already holds a value for a flag, that value's type is used as the
+// schema and the flag is parsed like a field of that type. Otherwise the type
+// is inferred: a flag without a value, or with the value true or false, is
+// stored as a bool; a value ParseInt accepts in base 10 as an int; and any
+// other value as a string. Short and long flags are stored under the names
+// they were given with.
+func ParseInto(m map[string]interface{}, args []string) ([]string, error) {
+	positionalArgs, flags := ParseArgsOrdered(args, ArgSpec{})
+	for _, flag := range flags {
+		if existing, ok := m[flag.Key]; ok && existing != nil {
+			value := reflect.New(reflect.TypeOf(existing)).Elem()
+			if err := SetField(value, flag.Value, true); err != nil {
+				return nil, fmt.Errorf("error parsing flag --%s: %v", flag.Key, err)
+			}
+			m[flag.Key] = value.Interface()
+			continue
+		}
+		m[flag.Key] = inferValue(flag.Value)
+	}
+	return positionalArgs, nil
+}
+
+// inferValue returns value as a bool, int or string, as described by
+// ParseInto.
+func inferValue(value string) interface{} {
+	switch value {
+	case "", "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseInt(value, 10, 0); err == nil {
+		return int(n)
+	}
+	return value
+}
+
+// setField is SetField for a struct field whose tags adjust the parsing. The
+// result must be one of the values listed in a choices tag.
+func setField(field reflect.Value, tag reflect.StructTag, value string, exists bool) error {
+	if err := setValue(field, tag, value, exists); err != nil {
+		return err
+	}
+	return checkChoices(field, tag)
+}
+
+// setValue parses value into field according to its type and tags.
+func setValue(field reflect.Value, tag reflect.StructTag, value string, exists bool) error {
+	isJSON := tag.Get("as") == "json"
+	if tag.Get("fromfile") == "true" && strings.HasPrefix(value, "@") && (isJSON || field.Kind() != reflect.Slice && field.Kind() != reflect.Map) {
+		// Slices and maps read files per element instead, unless given as JSON
+		data, err := os.ReadFile(value[1:])
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	}
+	if tag.Get("unquote") == "true" && field.Kind() == reflect.String {
+		unquoted, err := unquote(value)
+		if err != nil {
+			return err
+		}
+		value = unquoted
+	}
+	if names := tag.Get("normalize"); names != "" && field.Kind() == reflect.String {
+		normalized, err := normalize(value, names)
+		if err != nil {
+			return err
+		}
+		value = normalized
+	}
+	if sentinel, ok := tag.Lookup("nullable"); ok && value == sentinel && field.Kind() == reflect.Ptr {
+		// The sentinel resets the pointer, overriding a default
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	if isJSON {
+		ptr := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+			return fmt.Errorf("invalid JSON: %v", err)
+		}
+		field.Set(ptr.Elem())
+		return nil
+	}
+
+	if parse, ok := lookupParser(field.Type()); ok {
+		parsed, err := parse(value)
+		if err != nil {
+			return err
+		}
+		result := reflect.ValueOf(parsed)
+		if !result.IsValid() || !result.Type().AssignableTo(field.Type()) {
+			return fmt.Errorf("parser for %s returned %T", field.Type(), parsed)
+		}
+		field.Set(result)
+		return nil
+	}
+
+	switch field.Type() {
+	case ipType:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address %q", value)
+		}
+		field.Set(reflect.ValueOf(ip))
+		return nil
+	case ipNetType, ipNetPtrType:
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR network %q", value)
+		}
+		setPtrOrValue(field, reflect.ValueOf(ipNet))
+		return nil
+	case urlType, urlPtrType:
+		u, err := url.Parse(value)
+		if err != nil {
+			return fmt.Errorf("invalid URL %q: %v", value, err)
+		}
+		setPtrOrValue(field, reflect.ValueOf(u))
+		return nil
+	case timeType:
+		if value == "now" && tag.Get("allow_now") == "true" {
+			field.Set(reflect.ValueOf(time.Now()))
+			return nil
+		}
+		layout := tag.Get("layout")
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return fmt.Errorf("invalid time %q, expected layout %s", value, layout)
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+		return checkRange(field, tag)
+	case bigIntPtrType:
+		n, ok := new(big.Int).SetString(value, 0)
+		if !ok {
+			return fmt.Errorf("invalid big.Int value %q", value)
+		}
+		field.Set(reflect.ValueOf(n))
+		return nil
+	case bigFloatPtrType:
+		f, ok := new(big.Float).SetString(value)
+		if !ok {
+			return fmt.Errorf("invalid big.Float value %q", value)
+		}
+		field.Set(reflect.ValueOf(f))
+		return nil
+	}
+
+	// Handle types that implement encoding.TextUnmarshaler, including
+	// elements of slices
+	if field.Kind() == reflect.Ptr && field.Type().Implements(textUnmarshalerType) {
+		ptr := reflect.New(field.Type().Elem())
+		if err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	if field.CanAddr() && reflect.PtrTo(field.Type()).Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	// Handle types with a Set(string) error method, like the flag.Value
+	// implementations of the standard library flag package
+	if field.Kind() == reflect.Ptr && field.Type().Implements(valueSetterType) {
+		ptr := reflect.New(field.Type().Elem())
+		if err := ptr.Interface().(valueSetter).Set(value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	if field.CanAddr() && reflect.PtrTo(field.Type()).Implements(valueSetterType) {
+		return field.Addr().Interface().(valueSetter).Set(value)
+	}
+
+	if tag.Get("unit") == "bytes" {
+		return setBytes(field, value)
+	}
+	if as := tag.Get("as"); as == "rune" || as == "byte" {
+		return setChar(field, as, value)
+	}
+
+	// Other pointers are allocated and set through their element, so a nil
+	// *bool means the flag was not given
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setField(ptr.Elem(), tag, value, exists); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Base 0 accepts Go literals like 0xff, 0o755 and 0b1010
+		intValue, err := strconv.ParseInt(lenientInt(value), 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(intValue)
+		return checkRange(field, tag)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		uintValue, err := strconv.ParseUint(lenientInt(value), 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(uintValue)
+		return checkRange(field, tag)
+	case reflect.Bool:
+		if exists && value == "" {
+			field.SetBool(true)
+			return nil
+		}
+		if value != "" && slices.Contains(strings.Split(tag.Get("truthy"), ","), value) {
+			field.SetBool(true)
+			return nil
+		}
+		if value != "" && slices.Contains(strings.Split(tag.Get("falsy"), ","), value) {
+			field.SetBool(false)
+			return nil
+		}
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(boolValue)
+	case reflect.Float32, reflect.Float64:
+		number, err := lenientFloat(value)
+		if err != nil {
+			return err
+		}
+		floatValue, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(floatValue)
+		return checkRange(field, tag)
+	case reflect.Complex64, reflect.Complex128:
+		complexValue, err := strconv.ParseComplex(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q", field.Kind(), value)
+		}
+		field.SetComplex(complexValue)
+	case reflect.Slice:
+		// Assumes comma-separated values for slice types
+		list := splitList(value)
+		slice := reflect.MakeSlice(field.Type(), len(list), len(list))
+		for i, item := range list {
+			if err := setField(slice.Index(i), tag, item, exists); err != nil {
+				if _, ok := err.(*UnsupportedTypeError); ok {
+					return &UnsupportedTypeError{Type: field.Type()}
+				}
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		if tag.Get("unique") == "true" {
+			slice = uniqueSlice(slice)
+		}
+		field.Set(slice)
+	case reflect.Map:
+		// Assumes comma-separated key=value entries for map types
+		m := reflect.MakeMap(field.Type())
+		for _, entry := range splitList(value) {
+			k, v, ok := strings.Cut(entry, "=")
+			if !ok {
+				return fmt.Errorf("invalid map entry %q, expected key=value", entry)
+			}
+			key := reflect.New(field.Type().Key()).Elem()
+			if err := setField(key, tag, k, exists); err != nil {
+				if _, ok := err.(*UnsupportedTypeError); ok {
+					return &UnsupportedTypeError{Type: field.Type()}
+				}
+				return fmt.Errorf("key %q: %v", k, err)
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setField(elem, tag, v, exists); err != nil {
+				if _, ok := err.(*UnsupportedTypeError); ok {
+					return &UnsupportedTypeError{Type: field.Type()}
+				}
+				return fmt.Errorf("value of %q: %v", k, err)
+			}
+			m.SetMapIndex(key, elem)
+		}
+		field.Set(m)
+	default:
+		return &UnsupportedTypeError{Type: field.Type()}
+	}
+	return nil
+}
+
+// UnsupportedTypeError is returned for a field of a type that can't be parsed
+// from a string, such as a channel. Field and Flag are set by the functions
+// that know which field is being set.
+type UnsupportedTypeError struct {
+	Type  reflect.Type
+	Field string // Go field name
+	Flag  string // Long flag name, without dashes
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("unsupported flag type %s", e.Type)
+	}
+	return fmt.Sprintf("unsupported flag type %s for field %s (--%s)", e.Type, e.Field, e.Flag)
+}
+
+// fieldError adds the name of field to an UnsupportedTypeError.
+func fieldError(err error, field reflect.StructField) error {
+	if typeErr, ok := err.(*UnsupportedTypeError); ok && typeErr.Field == "" {
+		return &UnsupportedTypeError{Type: typeErr.Type, Field: field.Name, Flag: flagName(field)}
+	}
+	return err
+}
+
+// lenient makes numeric parsing forgive common formatting of pasted values.
+var lenient = false
+
+// SetLenientParsing sets whether numeric fields accept loosely formatted
+// values: surrounding whitespace for integers and floats, a zero fraction like
+// 8080.0 for integers and thousands separators like 1,234.5 for floats.
+// Invalid numbers are still an error. Disabled by default.
+func SetLenientParsing(enabled bool) {
+	lenient = enabled
+}
+
+// lenientInt returns value with surrounding whitespace and a zero fraction
+// removed when lenient parsing is enabled.
+func lenientInt(value string) string {
+	if !lenient {
+		return value
+	}
+	value = strings.TrimSpace(value)
+	if whole, fraction, ok := strings.Cut(value, "."); ok && whole != "" && fraction != "" && strings.Trim(fraction, "0") == "" {
+		return whole
+	}
+	return value
+}
+
+// thousandsPattern matches a number with well-formed thousands separators.
+var thousandsPattern = regexp.MustCompile(`^[-+]?\d{1,3}(,\d{3})*(\.\d+)?$`)
+
+// lenientFloat returns value with surrounding whitespace and thousands
+// separators removed when lenient parsing is enabled. Commas that don't
+// separate groups of three digits, as in 12,34, are an error.
+func lenientFloat(value string) (string, error) {
+	if !lenient {
+		return value, nil
+	}
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, ",") {
+		return value, nil
+	}
+	if !thousandsPattern.MatchString(value) {
+		return "", fmt.Errorf("invalid thousands separators in %q", value)
+	}
+	return strings.ReplaceAll(value, ",", ""), nil
+}
+
+// setChar sets an integer field tagged as:"rune" or as:"byte" to a single
+// character, so --delimiter=, sets it to ','. A single character is always
+// taken literally, so 9 is '9'. Longer values are parsed as a number, which
+// allows a tab as 0x9.
+func setChar(field reflect.Value, as, value string) error {
+	var n int64
+	r, size := utf8.DecodeRuneInString(value)
+	switch {
+	case as == "byte" && len(value) == 1:
+		n = int64(value[0])
+	case as == "rune" && size == len(value) && r != utf8.RuneError:
+		n = int64(r)
+	default:
+		parsed, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q, expected a single character or a number", as, value)
+		}
+		n = parsed
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.OverflowInt(n) {
+			return fmt.Errorf("%s %q out of range", as, value)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || field.OverflowUint(uint64(n)) {
+			return fmt.Errorf("%s %q out of range", as, value)
+		}
+		field.SetUint(uint64(n))
+	default:
+		return fmt.Errorf("as:%q requires an integer field", as)
+	}
+	return nil
+}
+
+// ruleError is the error of a value that breaks the rule of a tag, such as
+// min, max or choices.
+type ruleError struct {
+	rule string
+	msg  string
+}
+
+func (e *ruleError) Error() string {
+	return e.msg
+}
+
+// checkRange returns an error when a number is outside the bounds of the
+// min and max tags, which are parsed like the field itself, so a duration
+// takes bounds like max:"1m". Elements of a slice are checked one by one.
+func checkRange(field reflect.Value, tag reflect.StructTag) error {
+	for _, name := range []string{"min", "max"} {
+		bound := tag.Get(name)
+		if bound == "" {
+			continue
+		}
+		limit := reflect.New(field.Type()).Elem()
+		if err := setField(limit, "", bound, true); err != nil {
+			return &ruleError{name, fmt.Sprintf("invalid %s tag %q: %v", name, bound, err)}
+		}
+		cmp := compareNumbers(field, limit)
+		if name == "min" && cmp < 0 {
+			return &ruleError{name, fmt.Sprintf("%v is below the minimum of %s", field.Interface(), bound)}
+		}
+		if name == "max" && cmp > 0 {
+			return &ruleError{name, fmt.Sprintf("%v is above the maximum of %s", field.Interface(), bound)}
+		}
+	}
+	return nil
+}
+
+// checkChoices returns an error when the value of field, formatted like
+// MarshalArgs does, is not one of the values in its choices tag. Slices and
+// maps are skipped, as setField checks their elements one by one.
+func checkChoices(field reflect.Value, tag reflect.StructTag) error {
+	choices := tag.Get("choices")
+	if choices == "" || field.Kind() == reflect.Slice || field.Kind() == reflect.Map {
+		return nil
+	}
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return nil
+		}
+		field = field.Elem()
+	}
+	s := formatValue(field, tag)
+	if !slices.Contains(strings.Split(choices, ","), s) {
+		return &ruleError{"choices", fmt.Sprintf("%q is not one of %s", s, strings.ReplaceAll(choices, ",", ", "))}
+	}
+	return nil
+}
+
+// compareNumbers returns -1, 0 or 1 as a is less than, equal to or greater
+// than b, which must be numbers of the same kind.
+func compareNumbers(a, b reflect.Value) int {
+	var less, greater bool
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less, greater = a.Int() < b.Int(), a.Int() > b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		less, greater = a.Uint() < b.Uint(), a.Uint() > b.Uint()
+	case reflect.Float32, reflect.Float64:
+		less, greater = a.Float() < b.Float(), a.Float() > b.Float()
+	}
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// uniqueSlice returns the slice without repeated elements, keeping the first
+// occurrence of each.
+func uniqueSlice(slice reflect.Value) reflect.Value {
+	unique := reflect.MakeSlice(slice.Type(), 0, slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		elem := slice.Index(i)
+		seen := false
+		for j := 0; j < unique.Len() && !seen; j++ {
+			seen = reflect.DeepEqual(unique.Index(j).Interface(), elem.Interface())
+		}
+		if !seen {
+			unique = reflect.Append(unique, elem)
+		}
+	}
+	return unique
+}
+
+// byteUnits maps size suffixes to their multiplier. The SI suffixes are
+// powers of 1000 and the IEC suffixes powers of 1024.
+var byteUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// setBytes sets an integer field to the number of bytes of a size like 10MB
+// or 1.5GiB. A number without a suffix is a number of bytes.
+func setBytes(field reflect.Value, value string) error {
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if end < 0 {
+		end = len(value)
+	}
+	number, err := strconv.ParseFloat(value[:end], 64)
+	unit, ok := byteUnits[strings.ToUpper(strings.TrimSpace(value[end:]))]
+	if err != nil || !ok {
+		return fmt.Errorf("invalid size %q", value)
+	}
+
+	size := number * unit
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if size >= math.MaxInt64 || field.OverflowInt(int64(size)) {
+			return fmt.Errorf("size %q out of range", value)
+		}
+		field.SetInt(int64(size))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if size >= math.MaxUint64 || field.OverflowUint(uint64(size)) {
+			return fmt.Errorf("size %q out of range", value)
+		}
+		field.SetUint(uint64(size))
+	default:
+		return fmt.Errorf("unit:\"bytes\" requires an integer field, got %s", field.Type())
+	}
+	return nil
+}
+
+// normalizers are the functions of the normalize tag.
+var normalizers = map[string]func(string) string{
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.TrimSpace,
+	"cleanpath": filepath.Clean,
+}
+
+// normalize applies the comma-separated normalizers in names to value, in
+// order.
+func normalize(value, names string) (string, error) {
+	for _, name := range strings.Split(names, ",") {
+		fn, ok := normalizers[strings.TrimSpace(name)]
+		if !ok {
+			return "", fmt.Errorf("unknown normalizer %q", name)
+		}
+		value = fn(value)
+	}
+	return value, nil
+}
+
+// unquote strips a matching pair of surrounding quotes from value. Double
+// quoted values are unquoted with strconv.Unquote, so escapes like \" are
+// interpreted. Single quoted values are taken literally, as in a shell. A
+// value starting with a quote that isn't a well-formed quoted string is an
+// error, while values without a leading quote are returned unchanged.
+func unquote(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("malformed quoted value %s", value)
+		}
+		return unquoted, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("malformed quoted value %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	}
+	return value, nil
+}
+
+// splitList splits a comma-separated list. An element enclosed in double
+// quotes may contain commas, with "" standing for a literal quote, and \,
+// outside quotes is a literal comma. Input without quotes or escapes is split
+// exactly like strings.Split.
+func splitList(value string) []string {
+	var list []string
+	var elem strings.Builder
+	quoted, atStart := false, true
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quoted && c == '"' && i+1 < len(value) && value[i+1] == '"':
+			elem.WriteByte('"')
+			i++
+		case quoted && c == '"':
+			quoted = false
+		case quoted:
+			elem.WriteByte(c)
+		case c == '"' && atStart:
+			quoted = true
+		case c == '\\' && i+1 < len(value) && value[i+1] == ',':
+			elem.WriteByte(',')
+			i++
+		case c == ',':
+			list = append(list, elem.String())
+			elem.Reset()
+			atStart = true
+			continue
+		default:
+			elem.WriteByte(c)
+		}
+		atStart = false
+	}
+	return append(list, elem.String())
+}
+
+// joinList joins values into a comma-separated list that splitList splits
+// back into the same values, quoting values that contain commas or quotes.
+func joinList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		if strings.ContainsAny(value, ",\"") {
+			value = `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+		}
+		quoted[i] = value
+	}
+	return strings.Join(quoted, ",")
+}
+
+var (
+	ipType       = reflect.TypeOf(net.IP{})
+	ipNetType    = reflect.TypeOf(net.IPNet{})
+	ipNetPtrType = reflect.TypeOf(&net.IPNet{})
+	urlType      = reflect.TypeOf(url.URL{})
+	urlPtrType   = reflect.TypeOf(&url.URL{})
+
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	bigIntPtrType   = reflect.TypeOf(&big.Int{})
+	bigFloatPtrType = reflect.TypeOf(&big.Float{})
+
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	valueSetterType     = reflect.TypeOf((*valueSetter)(nil)).Elem()
+)
+
+// valueSetter is implemented by types that parse their own value, such as
+// implementations of flag.Value from the standard library.
+type valueSetter interface {
+	Set(string) error
+}
+
+// setPtrOrValue assigns the pointer ptr to field, or the value it points to
+// when field is not a pointer.
+func setPtrOrValue(field reflect.Value, ptr reflect.Value) {
+	if field.Kind() == reflect.Ptr {
+		field.Set(ptr)
+	} else {
+		field.Set(ptr.Elem())
+	}
+}
+
+// ParseEnv parses environment variables and populates the config struct.
+func ParseEnv(config interface{}) error {
+	return parseEnv(config, os.LookupEnv, nil)
+}
+
+// ParseEnvFrom is like ParseEnv but looks up the variables in env instead of
+// the process environment.
+func ParseEnvFrom(config interface{}, env map[string]string) error {
+	return parseEnv(config, func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}, nil)
+}
+
+func parseEnv(config interface{}, lookupEnv func(string) (string, bool), sources Sources) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+
+	for _, sf := range structFields(v) {
+		if !allowsSource(sf.StructField, "env") || isNestedStruct(sf) {
+			continue // Nested structs are only set from flags
+		}
+		field := sf.Value
+		fieldType := sf.StructField
+		envName := envName(fieldType)
+
+		envValue, exists := lookupEnv(envName)
+		if !exists {
+			continue // If environment variable is not set, skip setting the field
+		}
+		if envValue == "" && isBool(fieldType.Type) {
+			continue // VERBOSE= means unset rather than true
+		}
+		if skipField(sources, fieldType.Name) {
+			continue
+		}
+
+		err := setField(field, fieldType.Tag, envValue, true)
+		if err != nil {
+			// PrintDefaults(config) // Print help message if there's an error setting the field
+			return fmt.Errorf("error setting environment variable %s: %v", envName, fieldError(err, fieldType))
+		}
+		sources.set(fieldType.Name, SourceEnv)
+	}
+
+	return nil
+}
+
+// ErrHelp is returned by ParseAll when --help or -h was given and the help
+// message has been printed.
+var ErrHelp = errors.New("flag: help requested")
+
+// SetAll configures the application settings by setting defaults, parsing environment variables,
+// and command-line arguments. It also checks for help flags (--help, -h) to display help messages.
+func ParseAll(config interface{}, args []string) ([]string, map[string]string, error) {
+	return ParseAllContext(context.Background(), config, args)
+}
+
+// ErrConfigDump is returned by ParseAll when the flag set with
+// SetConfigDumpFlag was given and the config has been printed.
+var ErrConfigDump = errors.New("flag: config dump requested")
+
+// configDumpFlag is the long flag that prints the config, empty when disabled.
+var configDumpFlag = ""
+
+// SetConfigDumpFlag sets a long flag, such as "print-config", that makes
+// ParseAll print the resolved config with DumpConfig and return
+// ErrConfigDump. The config is printed after defaults, environment variables
+// and flags have been applied, but before validation. An empty name disables
+// the flag, which is the default.
+func SetConfigDumpFlag(name string) {
+	configDumpFlag = name
+}
+
+// autoHelp enables the --help and -h handling of ParseAll.
+var autoHelp = true
+
+// SetAutoHelp sets whether ParseAll handles --help and -h by printing the help
+// and returning ErrHelp, which it does by default. When disabled, both are
+// parsed like any other flag, so a field can use -h for something else.
+func SetAutoHelp(enabled bool) {
+	autoHelp = enabled
+}
+
+// ParseAllContext is like ParseAll but stops with ctx.Err() when ctx is done
+// before any of the defaults, environment or flags stages.
+func ParseAllContext(ctx context.Context, config interface{}, args []string) ([]string, map[string]string, error) {
+	result, err := parseAll(ctx, config, args, os.LookupEnv, helpOutput())
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.RemainingArgs, result.Flags.Map(), nil
+}
+
+// ParseAllSources is like ParseAll but also returns the source that set each field.
+func ParseAllSources(config interface{}, args []string) ([]string, map[string]string, Sources, error) {
+	result, err := parseAll(context.Background(), config, args, os.LookupEnv, helpOutput())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return result.RemainingArgs, result.Flags.Map(), result.Sources, nil
+}
+
+// ParseResult is like ParseAll but returns the results as a Result.
+func ParseResult(config interface{}, args []string) (*Result, error) {
+	return parseAll(context.Background(), config, args, os.LookupEnv, helpOutput())
+}
+
+// parseAll parses config from the defaults, the variables found with
+// lookupEnv and args, and writes help output to out.
+func parseAll(ctx context.Context, config interface{}, args []string, lookupEnv func(string) (string, bool), out io.Writer) (*Result, error) {
+	runPreParse(args)
+	result, err := parse(ctx, config, args, lookupEnv, out)
+	runPostParse(config, err)
+	return result, err
+}
+
+// precedence is the order in which ParseAll applies the sources, with later
+// sources overriding earlier ones.
+var precedence = []Source{SourceDefault, SourceEnv, SourceFlag}
+
+// SetPrecedence sets the order in which ParseAll applies defaults, environment
+// variables and flags, with later sources overriding earlier ones. The order
+// SourceDefault, SourceFlag, SourceEnv lets environment variables win over
+// flags, for settings injected by an orchestrator. order must start with
+// SourceDefault and hold each of the three sources exactly once. The default
+// order is SourceDefault, SourceEnv, SourceFlag.
+func SetPrecedence(order []Source) error {
+	if len(order) == 0 || order[0] != SourceDefault {
+		return errors.New("precedence must start with the default source")
+	}
+	seen := make(map[Source]bool)
+	for _, source := range order {
+		if source != SourceDefault && source != SourceEnv && source != SourceFlag {
+			return fmt.Errorf("invalid precedence source %s", source)
+		}
+		if seen[source] {
+			return fmt.Errorf("source %s appears more than once in precedence", source)
+		}
+		seen[source] = true
+	}
+	if len(seen) != 3 {
+		return errors.New("precedence must hold the default, env and flag sources")
+	}
+	precedence = append([]Source(nil), order...)
+	return nil
+}
+
+// Precedence returns the order set with SetPrecedence, so it can be restored.
+func Precedence() []Source {
+	return append([]Source(nil), precedence...)
+}
+
+// skipSetFields makes later stages leave fields alone that earlier ones set.
+var skipSetFields = false
+
+// SetSkipSetFields sets whether the environment and flag stages of ParseAll
+// skip fields that an earlier one of them already set, so the first source
+// in the precedence order wins instead of the last. Defaults are always
+// overridden. Disabled by default.
+func SetSkipSetFields(enabled bool) {
+	skipSetFields = enabled
+}
+
+// skipField reports whether a stage should leave field alone because an
+// earlier stage set it and SetSkipSetFields is enabled.
+func skipField(sources Sources, field string) bool {
+	return skipSetFields && sources.IsSet(field)
+}
+
+func parse(ctx context.Context, config interface{}, args []string, lookupEnv func(string) (string, bool), out io.Writer) (*Result, error) {
+	sources := make(Sources)
+	if err := ValidateNames(config); err != nil {
+		return nil, fmt.Errorf("error validating names: %v", err)
+	}
+	var outArgs []string
+	var flags Flags
+	for _, source := range precedence {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		switch source {
+		case SourceDefault:
+			var err error
+			if provider, ok := config.(DefaultsProvider); ok {
+				err = setDefaultsFrom(config, provider.Defaults(), lookupEnv, sources)
+			} else {
+				err = setDefaults(config, lookupEnv, sources)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error setting default values: %v", err)
+			}
+		case SourceEnv:
+			if err := parseEnv(config, lookupEnv, sources); err != nil {
+				return nil, fmt.Errorf("error parsing environment variables: %v", err)
+			}
+		case SourceFlag:
+			for _, arg := range args {
+				if arg == "--" {
+					break // Arguments after -- are never flags
+				}
+				if autoHelp && (arg == "--help" || arg == "-h") {
+					fmt.Fprintln(out, message("usage_header"))
+					printDefaults(out, config, false, lookupEnv)
+					return nil, ErrHelp
+				}
+			}
+			outArgs, flags = ParseArgsOrdered(args, NewArgSpec(config))
+			if strict {
+				if err := checkUnknown(config, flags); err != nil {
+					return nil, fmt.Errorf("error parsing command-line arguments: %v", err)
+				}
+			}
+			if err := setFlags(config, flags, sources); err != nil {
+				return nil, fmt.Errorf("error parsing command-line arguments: %v", err)
+			}
+		}
+	}
+	outArgs, err := bindRest(config, args, outArgs, sources)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing command-line arguments: %v", err)
+	}
+	if err := bindArgs(config, outArgs, sources); err != nil {
+		return nil, fmt.Errorf("error parsing command-line arguments: %v", err)
+	}
+	if defaulter, ok := config.(Defaulter); ok {
+		defaulter.ApplyDefaults(sources)
+	}
+	if err := interpolate(config); err != nil {
+		return nil, fmt.Errorf("error resolving references: %v", err)
+	}
+	if _, ok := flags.Get(configDumpFlag); ok && configDumpFlag != "" {
+		fmt.Fprintln(out, DumpConfig(config))
+		return nil, ErrConfigDump
+	}
+	if err := checkPositional(len(outArgs)); err != nil {
+		return nil, err
+	}
+	if err := fillRequired(config, sources); err != nil {
+		return nil, fmt.Errorf("error validating config: %v", err)
+	}
+	if err := checkRelations(config, sources); err != nil {
+		return nil, fmt.Errorf("error validating config: %v", err)
+	}
+	if validator, ok := config.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, fmt.Errorf("error validating config: %w", err)
+		}
+	}
+	return &Result{
+		RemainingArgs: outArgs,
+		Flags:         flags,
+		Sources:       sources,
+		Warnings:      deprecations(config, sources),
+	}, nil
+}
+
+// bindArgs sets the fields tagged arg to the positional arguments, in
+// declaration order. A slice field takes all remaining arguments, so it should
+// come last. Fields without an argument keep their value.
+func bindArgs(config interface{}, args []string, sources Sources) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+	return walkFields(v, "", func(sf structField, path string) error {
+		name := sf.Tag.Get("arg")
+		if name == "" || len(args) == 0 {
+			return nil
+		}
+		value := args[0]
+		args = args[1:]
+		if sf.Type.Kind() == reflect.Slice {
+			// Each argument is one element, without splitting on commas
+			slice := reflect.MakeSlice(sf.Type, 0, len(args)+1)
+			for _, arg := range append([]string{value}, args...) {
+				elem := reflect.New(sf.Type.Elem()).Elem()
+				if err := setField(elem, sf.Tag, arg, true); err != nil {
+					return fmt.Errorf("error parsing argument %s: %v", name, err)
+				}
+				slice = reflect.Append(slice, elem)
+			}
+			sf.Value.Set(slice)
+			args = nil
+		} else if err := setField(sf.Value, sf.Tag, value, true); err != nil {
+			return fmt.Errorf("error parsing argument %s: %v", name, err)
+		}
+		sources.set(sf.Name, SourceArg)
+		return nil
+	})
+}
+
+// bindRest sets the []string field tagged rest:"true" to the arguments after
+// the -- terminator, without splitting them on commas, and returns the
+// positional arguments without them. Only one field may be tagged rest.
+// Without such a field the positional arguments are returned unchanged.
+func bindRest(config interface{}, args, positionalArgs []string, sources Sources) ([]string, error) {
+	v, err := configStruct(config)
+	if err != nil {
+		return nil, err
+	}
+	var rest *structField
+	for _, sf := range structFields(v) {
+		if sf.Tag.Get("rest") != "true" {
+			continue
+		}
+		if rest != nil {
+			return nil, fmt.Errorf("only one field may be tagged rest, got %s and %s", rest.Name, sf.Name)
+		}
+		if sf.Type != reflect.TypeOf([]string(nil)) {
+			return nil, fmt.Errorf("field %s tagged rest must be a []string, got %s", sf.Name, sf.Type)
+		}
+		rest = &sf
+	}
+	// -- is never taken as the value of a flag, so the first is the terminator
+	i := slices.Index(args, "--")
+	if rest == nil || i < 0 {
+		return positionalArgs, nil
+	}
+	tail := append([]string{}, args[i+1:]...)
+	rest.Value.Set(reflect.ValueOf(tail))
+	sources.set(rest.Name, SourceArg)
+	if len(tail) > len(positionalArgs) {
+		return positionalArgs, nil // Flags weren't parsed from args
+	}
+	return positionalArgs[:len(positionalArgs)-len(tail)], nil
+}
+
+// Defaulter is implemented by configs with defaults that depend on other
+// values. ParseAll calls ApplyDefaults after defaults, environment variables,
+// flags and positional arguments have been set, before validation. Fields
+// that sources reports as not IsSet were left at their default and can be
+// given a computed one.
+type Defaulter interface {
+	ApplyDefaults(sources Sources)
+}
+
+// Validator is implemented by configs that check their own values.
+// ParseAll calls Validate after all values have been set and wraps the error
+// it returns, so errors.As finds it.
+type Validator interface {
+	Validate() error
+}
+
+// Positional argument counts accepted by ParseAll. A max of -1 means no limit.
+var (
+	minPositional = 0
+	maxPositional = -1
+)
+
+// RequirePositional sets the number of positional arguments ParseAll accepts.
+// A max of -1 means no upper limit. RequirePositional(0, -1) restores the
+// default of accepting any number.
+func RequirePositional(min, max int) {
+	minPositional, maxPositional = min, max
+}
+
+// checkPositional checks n positional arguments against RequirePositional.
+func checkPositional(n int) error {
+	if n >= minPositional && (maxPositional < 0 || n <= maxPositional) {
+		return nil
+	}
+	switch {
+	case minPositional == maxPositional:
+		return fmt.Errorf("expected %s, got %d", arguments(minPositional), n)
+	case maxPositional < 0:
+		return fmt.Errorf("expected at least %s, got %d", arguments(minPositional), n)
+	case minPositional == 0:
+		return fmt.Errorf("expected at most %s, got %d", arguments(maxPositional), n)
+	}
+	return fmt.Errorf("expected between %d and %s, got %d", minPositional, arguments(maxPositional), n)
+}
+
+// arguments returns n followed by "argument" or "arguments".
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// interpolate replaces references like ${app-name} in string fields tagged
+// interpolate:"true" with the value of the flag of that name. A referenced
+// field that is itself interpolated is resolved first, and a cycle of
+// references is an error, as is a reference to an unknown flag.
+func interpolate(config interface{}) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+	var names []string
+	byName := make(map[string]structField)
+	walkFields(v, "", func(sf structField, path string) error {
+		names = append(names, path)
+		byName[path] = sf
+		return nil
+	})
+
+	resolved := make(map[string]bool)
+	var resolve func(name string, path []string) error
+	resolve = func(name string, path []string) error {
+		sf := byName[name]
+		if resolved[name] || sf.Tag.Get("interpolate") != "true" || sf.Type.Kind() != reflect.String {
+			return nil
+		}
+		if slices.Contains(path, name) {
+			return fmt.Errorf("cyclic reference --%s", strings.Join(append(path, name), " -> --"))
+		}
+		path = append(path, name)
+
+		var b strings.Builder
+		value := sf.Value.String()
+		for {
+			start := strings.Index(value, "${")
+			if start < 0 {
+				break
+			}
+			length := strings.IndexByte(value[start:], '}')
+			if length < 0 {
+				break
+			}
+			end := start + length
+			ref := value[start+2 : end]
+			other, ok := byName[ref]
+			if !ok {
+				return fmt.Errorf("flag --%s refers to unknown flag --%s", name, ref)
+			}
+			if err := resolve(ref, path); err != nil {
+				return err
+			}
+			b.WriteString(value[:start])
+			b.WriteString(formatValue(other.Value, other.Tag))
+			value = value[end+1:]
+		}
+		b.WriteString(value)
+		sf.Value.SetString(b.String())
+		resolved[name] = true
+		return nil
+	}
+	for _, name := range names {
+		if err := resolve(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRelations checks the exclusive and requires tags. Fields sharing an
+// exclusive group can't be set together, by env or flag. A field tagged
+// requires:"a,b" that is set needs the fields with flag names a and b to hold
+// a non-zero value, whether from a default, env or flag.
+func checkRelations(config interface{}, sources Sources) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+
+	fields := structFields(v)
+	byName := make(map[string]structField, len(fields))
+	for _, sf := range fields {
+		byName[flagName(sf.StructField)] = sf
+	}
+
+	var errs []string
+	var groups []string
+	exclusive := make(map[string][]string)
+	for _, sf := range fields {
+		if !sources.IsSet(sf.Name) {
+			continue
+		}
+		name := flagName(sf.StructField)
+		if group := sf.Tag.Get("exclusive"); group != "" {
+			if exclusive[group] == nil {
+				groups = append(groups, group)
+			}
+			exclusive[group] = append(exclusive[group], "--"+name)
+		}
+		for _, required := range strings.Split(sf.Tag.Get("requires"), ",") {
+			if required == "" {
+				continue
+			}
+			if other, ok := byName[required]; !ok || other.Value.IsZero() {
+				errs = append(errs, fmt.Sprintf("flag --%s requires --%s", name, required))
+			}
+		}
+	}
+	for _, group := range groups {
+		if names := exclusive[group]; len(names) > 1 {
+			errs = append(errs, fmt.Sprintf("flags %s can't be used together", strings.Join(names, ", ")))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
 }
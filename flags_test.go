@@ -1,13 +1,23 @@
 package flag_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math/big"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/bartdeboer/flag"
+	"github.com/bartdeboer/words"
 )
 
 func TestPrintDefaults(t *testing.T) {
@@ -129,6 +139,14 @@ func TestSetField(t *testing.T) {
 	}{
 		{"int", "123", reflect.TypeOf(int(0)), int(123), false},
 		{"int overflow", "99999999999999999999", reflect.TypeOf(int(0)), nil, true},
+		{"int hex", "0xFF", reflect.TypeOf(int(0)), int(255), false},
+		{"int octal", "0o17", reflect.TypeOf(int(0)), int(15), false},
+		{"int leading zero octal", "017", reflect.TypeOf(int(0)), int(15), false},
+		{"int binary", "0b1010", reflect.TypeOf(int(0)), int(10), false},
+		{"int negative hex", "-0x10", reflect.TypeOf(int(0)), int(-16), false},
+		{"int8 overflow", "300", reflect.TypeOf(int8(0)), nil, true},
+		{"uint hex", "0xff", reflect.TypeOf(uint8(0)), uint8(255), false},
+		{"uint8 overflow", "0x100", reflect.TypeOf(uint8(0)), nil, true},
 		{"bool true", "true", reflect.TypeOf(bool(false)), true, false},
 		{"bool false", "false", reflect.TypeOf(bool(false)), false, false},
 		{"bool invalid", "maybe", reflect.TypeOf(bool(false)), nil, true},
@@ -136,6 +154,15 @@ func TestSetField(t *testing.T) {
 		{"float", "3.14159", reflect.TypeOf(float64(0)), 3.14159, false},
 		{"float invalid", "pi", reflect.TypeOf(float64(0)), nil, true},
 		{"slice strings", "one,two,three", reflect.TypeOf([]string{}), []string{"one", "two", "three"}, false},
+		{"slice empty elements", "a,,b,", reflect.TypeOf([]string{}), []string{"a", "", "b", ""}, false},
+		{"slice quoted", `"a,b",c`, reflect.TypeOf([]string{}), []string{"a,b", "c"}, false},
+		{"slice quoted quote", `"say ""hi""",x`, reflect.TypeOf([]string{}), []string{`say "hi"`, "x"}, false},
+		{"slice escaped comma", `a\,b,c`, reflect.TypeOf([]string{}), []string{"a,b", "c"}, false},
+		{"slice ints", "1,2,3", reflect.TypeOf([]int{}), []int{1, 2, 3}, false},
+		{"slice floats", "1.5,2", reflect.TypeOf([]float64{}), []float64{1.5, 2}, false},
+		{"slice bools", "true,false", reflect.TypeOf([]bool{}), []bool{true, false}, false},
+		{"slice invalid element", "1,x", reflect.TypeOf([]int{}), nil, true},
+		{"slice inner quote", `a"b,c`, reflect.TypeOf([]string{}), []string{`a"b`, "c"}, false},
 	}
 
 	for _, tc := range tests {
@@ -248,8 +275,8 @@ func TestParseAll(t *testing.T) {
 	}
 
 	// Verify that the function exits after printing help
-	if err != nil || remainingArgs != nil {
-		t.Errorf("Expected no error and nil remainingArgs when help is printed, got error: %v, remainingArgs: %v", err, remainingArgs)
+	if !errors.Is(err, ErrHelp) || remainingArgs != nil {
+		t.Errorf("Expected ErrHelp and nil remainingArgs when help is printed, got error: %v, remainingArgs: %v", err, remainingArgs)
 	}
 
 	// Normal operation without --help
@@ -274,3 +301,2742 @@ func TestParseAll(t *testing.T) {
 		t.Errorf("Expected no remaining arguments, got %v", remainingArgs)
 	}
 }
+
+// captureStdout returns everything f writes to os.Stdout.
+func captureStdout(f func()) string {
+	originalStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	f()
+
+	w.Close()
+	out, _ := io.ReadAll(r)
+	os.Stdout = originalStdout
+	return string(out)
+}
+
+func TestPrintDefaultsWrap(t *testing.T) {
+	type Config struct {
+		PortNumber int    `usage:"Port to listen on for incoming connections from clients" short:"p"`
+		HostName   string `usage:"Host address"`
+	}
+
+	defer ResetHelpWidth()()
+	SetHelpWidth(50)
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+
+	expected := `  -p --port-number int   Port to listen on for
+                         incoming connections from
+                         clients
+     --host-name string  Host address
+`
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+
+	SetHelpWidth(0)
+	output = captureStdout(func() { PrintDefaults(&Config{}) })
+	if !strings.Contains(output, "Port to listen on for incoming connections from clients\n") {
+		t.Errorf("Expected unwrapped usage with width 0, got:\n%s", output)
+	}
+
+	type Accented struct {
+		PortNumber int    `usage:"Écouté à côté du réseau des clients" short:"p"`
+		Host       string `flag:"hôte" usage:"Hôte"`
+	}
+	SetHelpWidth(50)
+	output = captureStdout(func() { PrintDefaults(&Accented{}) })
+	expected = `  -p --port-number int  Écouté à côté du réseau
+                        des clients
+     --hôte string      Hôte
+`
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestPrintDefaultsGroups(t *testing.T) {
+	type Config struct {
+		PortNumber int    `usage:"Port to listen on" short:"p" group:"Server"`
+		LogLevel   string `usage:"Log level" group:"Logging"`
+		HostName   string `usage:"Host address" group:"Server"`
+		Verbose    bool   `usage:"Verbose mode" short:"v"`
+		LogFile    string `usage:"Log file" group:"Logging"`
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+
+	expected := `Server options:
+  -p --port-number int   Port to listen on
+     --host-name string  Host address
+
+Logging options:
+     --log-level string  Log level
+     --log-file string   Log file
+
+Options:
+  -v --verbose bool      Verbose mode
+`
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestSetDefaultsExpand(t *testing.T) {
+	type Config struct {
+		DataDir string `default:"${APP_HOME}/.myapp" expand:"true"`
+		Missing string `default:"$APP_MISSING/x" expand:"true"`
+		Literal string `default:"${APP_HOME}/.myapp"`
+	}
+
+	os.Setenv("APP_HOME", "/home/user")
+	defer os.Unsetenv("APP_HOME")
+
+	var config Config
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+
+	if config.DataDir != "/home/user/.myapp" {
+		t.Errorf("Expected expanded default '/home/user/.myapp', got '%s'", config.DataDir)
+	}
+	if config.Missing != "/x" {
+		t.Errorf("Expected unknown variable to expand to empty, got '%s'", config.Missing)
+	}
+	if config.Literal != "${APP_HOME}/.myapp" {
+		t.Errorf("Expected literal default without expand tag, got '%s'", config.Literal)
+	}
+}
+
+func TestDefaultEnv(t *testing.T) {
+	type Config struct {
+		Editor  string `defaultEnv:"FALLBACK"`
+		Pager   string `default:"less" defaultEnv:"FALLBACK"`
+		Browser string `defaultEnv:"FALLBACK_MISSING"`
+	}
+
+	os.Setenv("FALLBACK", "vim")
+	defer os.Unsetenv("FALLBACK")
+
+	var config Config
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if config.Editor != "vim" {
+		t.Errorf("Expected Editor from FALLBACK 'vim', got '%s'", config.Editor)
+	}
+	if config.Pager != "less" {
+		t.Errorf("Expected the default tag to win, got '%s'", config.Pager)
+	}
+	if config.Browser != "" {
+		t.Errorf("Expected Browser to stay empty, got '%s'", config.Browser)
+	}
+
+	config = Config{}
+	if _, _, err := ParseAll(&config, []string{"--editor=nano"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Editor != "nano" {
+		t.Errorf("Expected the flag to override the fallback, got '%s'", config.Editor)
+	}
+}
+
+type tlsConfig struct {
+	TLS      bool
+	CertFile string
+}
+
+type dependencyError struct {
+	Field, Dependency string
+}
+
+func (e *dependencyError) Error() string {
+	return e.Field + " is required when " + e.Dependency + " is enabled"
+}
+
+func (c *tlsConfig) Validate() error {
+	if c.TLS && c.CertFile == "" {
+		return &dependencyError{"cert-file", "tls"}
+	}
+	return nil
+}
+
+func TestParseAllValidate(t *testing.T) {
+	var config tlsConfig
+	_, _, err := ParseAll(&config, []string{"--tls"})
+	if err == nil {
+		t.Fatal("Expected validation error, got none")
+	}
+	expectedErrorMessage := "error validating config: cert-file is required when tls is enabled"
+	if err.Error() != expectedErrorMessage {
+		t.Errorf("Expected error '%s', got '%s'", expectedErrorMessage, err.Error())
+	}
+	var dependency *dependencyError
+	if !errors.As(err, &dependency) || dependency.Field != "cert-file" {
+		t.Errorf("Expected the Validate error to be unwrappable, got %#v", err)
+	}
+
+	config = tlsConfig{}
+	if _, _, err := ParseAll(&config, []string{"--tls", "--cert-file=cert.pem"}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+type deployConfig struct {
+	Env      string `default:"development"`
+	LogLevel string
+}
+
+func (c *deployConfig) ApplyDefaults(sources Sources) {
+	if sources.IsSet("LogLevel") {
+		return
+	}
+	if c.Env == "production" {
+		c.LogLevel = "warn"
+	} else {
+		c.LogLevel = "debug"
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{}, "debug"},
+		{[]string{"--env", "production"}, "warn"},
+		{[]string{"--env", "production", "--log-level", "info"}, "info"},
+	}
+
+	for _, tc := range testCases {
+		var config deployConfig
+		if _, _, err := ParseAll(&config, tc.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tc.args, err)
+		}
+		if config.LogLevel != tc.expected {
+			t.Errorf("ParseAll(%v) got log level '%s', want '%s'", tc.args, config.LogLevel, tc.expected)
+		}
+	}
+}
+
+type buildConfig struct {
+	Target string `arg:"target"`
+	Output string
+}
+
+func (c *buildConfig) ApplyDefaults(sources Sources) {
+	if !sources.IsSet("Target") {
+		c.Target = "all"
+	}
+	if !sources.IsSet("Output") {
+		c.Output = c.Target + ".out"
+	}
+}
+
+func TestApplyDefaultsPositional(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected buildConfig
+	}{
+		{[]string{}, buildConfig{"all", "all.out"}},
+		{[]string{"app"}, buildConfig{"app", "app.out"}},
+		{[]string{"app", "--output=bin"}, buildConfig{"app", "bin"}},
+	}
+
+	for _, tc := range testCases {
+		var config buildConfig
+		if _, _, err := ParseAll(&config, tc.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tc.args, err)
+		}
+		if config != tc.expected {
+			t.Errorf("ParseAll(%v) got %+v, want %+v", tc.args, config, tc.expected)
+		}
+	}
+}
+
+type CommonFlags struct {
+	Verbose bool   `usage:"Verbose mode" short:"v"`
+	Name    string `usage:"Common name" default:"common"`
+}
+
+type ServerFlags struct {
+	Port int `usage:"Port to listen on" default:"8080"`
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	type Config struct {
+		CommonFlags
+		*ServerFlags
+		Name string `usage:"Outer name" default:"outer"`
+	}
+
+	os.Setenv("PORT", "3000")
+	defer os.Unsetenv("PORT")
+
+	config := Config{ServerFlags: &ServerFlags{}}
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if config.Name != "outer" || config.CommonFlags.Name != "" {
+		t.Errorf("Expected outer Name to shadow embedded Name, got outer '%s', embedded '%s'", config.Name, config.CommonFlags.Name)
+	}
+
+	if err := ParseEnv(&config); err != nil {
+		t.Fatalf("ParseEnv failed: %v", err)
+	}
+	if config.Port != 3000 {
+		t.Errorf("Expected port 3000 from env, got %d", config.Port)
+	}
+
+	_, flags := ParseArgs([]string{"--verbose", "--name=cli"})
+	if err := SetFlags(&config, flags); err != nil {
+		t.Fatalf("SetFlags failed: %v", err)
+	}
+	if !config.Verbose {
+		t.Errorf("Expected embedded verbose to be set")
+	}
+	if config.Name != "cli" || config.CommonFlags.Name != "" {
+		t.Errorf("Expected --name to set outer Name only, got outer '%s', embedded '%s'", config.Name, config.CommonFlags.Name)
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{ServerFlags: &ServerFlags{}}) })
+	expected := `  -v --verbose bool  Verbose mode
+     --port int      Port to listen on (default 8080)
+     --name string   Outer name (default outer)
+`
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestSkipField(t *testing.T) {
+	type Config struct {
+		Port   int    `usage:"Port to listen on" default:"8080"`
+		SkipMe string `flag:"-" default:"default"`
+	}
+
+	os.Setenv("SKIP_ME", "env")
+	defer os.Unsetenv("SKIP_ME")
+
+	config := Config{SkipMe: "internal"}
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if err := ParseEnv(&config); err != nil {
+		t.Fatalf("ParseEnv failed: %v", err)
+	}
+	_, flags := ParseArgs([]string{"--skip-me=flag", "--port=9090"})
+	if err := SetFlags(&config, flags); err != nil {
+		t.Fatalf("SetFlags failed: %v", err)
+	}
+	if config.SkipMe != "internal" {
+		t.Errorf("Expected skipped field to keep 'internal', got '%s'", config.SkipMe)
+	}
+	if config.Port != 9090 {
+		t.Errorf("Expected port 9090, got %d", config.Port)
+	}
+
+	output := captureStdout(func() { PrintDefaults(&config) })
+	if strings.Contains(output, "skip-me") {
+		t.Errorf("Expected skipped field to be absent from help, got:\n%s", output)
+	}
+}
+
+func TestParseAllFlags(t *testing.T) {
+	type Config struct {
+		PortNumber int    `default:"8080"`
+		HostName   string `default:"localhost"`
+	}
+
+	var config Config
+	_, flags, err := ParseAll(&config, []string{"--host-name=example.com", "--unknown"})
+	if err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+
+	expected := map[string]string{"host-name": "example.com", "unknown": ""}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Expected flags %v, got %v", expected, flags)
+	}
+	if _, exists := flags["port-number"]; exists {
+		t.Errorf("Expected defaulted port-number to be absent from flags")
+	}
+}
+
+func TestNetworkTypes(t *testing.T) {
+	type Config struct {
+		Bind     net.IP    `default:"127.0.0.1"`
+		Subnet   net.IPNet `default:"10.0.0.0/8"`
+		Endpoint url.URL   `default:"http://localhost:8080/api"`
+		Proxy    *url.URL
+	}
+
+	var config Config
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if !config.Bind.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected bind 127.0.0.1, got %v", config.Bind)
+	}
+	if config.Subnet.String() != "10.0.0.0/8" {
+		t.Errorf("Expected subnet 10.0.0.0/8, got %v", config.Subnet.String())
+	}
+	if config.Endpoint.Host != "localhost:8080" || config.Endpoint.Path != "/api" {
+		t.Errorf("Expected endpoint http://localhost:8080/api, got %v", config.Endpoint.String())
+	}
+
+	_, flags := ParseArgs([]string{"--bind=::1", "--subnet=192.168.1.0/24", "--proxy=http://proxy:3128"})
+	if err := SetFlags(&config, flags); err != nil {
+		t.Fatalf("SetFlags failed: %v", err)
+	}
+	if !config.Bind.Equal(net.ParseIP("::1")) {
+		t.Errorf("Expected bind ::1, got %v", config.Bind)
+	}
+	if config.Subnet.String() != "192.168.1.0/24" {
+		t.Errorf("Expected subnet 192.168.1.0/24, got %v", config.Subnet.String())
+	}
+	if config.Proxy == nil || config.Proxy.Host != "proxy:3128" {
+		t.Errorf("Expected proxy http://proxy:3128, got %v", config.Proxy)
+	}
+
+	errorCases := map[string]string{
+		"--bind=localhost":     "invalid IP address \"localhost\"",
+		"--subnet=10.0.0.0":    "invalid CIDR network \"10.0.0.0\"",
+		"--endpoint=http://%x": "invalid URL \"http://%x\"",
+	}
+	for arg, expectedErrorMessage := range errorCases {
+		_, flags := ParseArgs([]string{arg})
+		err := SetFlags(&config, flags)
+		if err == nil || !strings.Contains(err.Error(), expectedErrorMessage) {
+			t.Errorf("Expected error containing '%s' for %s, got %v", expectedErrorMessage, arg, err)
+		}
+	}
+}
+
+func TestSliceDefaults(t *testing.T) {
+	type Config struct {
+		Names  []string  `usage:"Names" default:"a,b,c"`
+		Ports  []int     `usage:"Ports" default:"80,443"`
+		Ratios []float64 `usage:"Ratios" default:"0.5,1.5"`
+		Empty  []string  `usage:"Empty" default:""`
+	}
+
+	var config Config
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if !reflect.DeepEqual(config.Names, []string{"a", "b", "c"}) {
+		t.Errorf("Expected names [a b c], got %v", config.Names)
+	}
+	if !reflect.DeepEqual(config.Ports, []int{80, 443}) {
+		t.Errorf("Expected ports [80 443], got %v", config.Ports)
+	}
+	if !reflect.DeepEqual(config.Ratios, []float64{0.5, 1.5}) {
+		t.Errorf("Expected ratios [0.5 1.5], got %v", config.Ratios)
+	}
+	if config.Empty != nil {
+		t.Errorf("Expected empty default to leave slice nil, got %#v", config.Empty)
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := `     --names []string    Names (default a,b,c)
+     --ports []int       Ports (default 80,443)
+     --ratios []float64  Ratios (default 0.5,1.5)
+     --empty []string    Empty
+`
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestPrintDefaultsHints(t *testing.T) {
+	type Config struct {
+		Version  string  `usage:"Version" default:"0"`
+		Retries  int     `usage:"Retries" default:"0"`
+		Ratio    float64 `usage:"Ratio" default:"0.0"`
+		Enabled  bool    `usage:"Enabled" default:"false"`
+		Password string  `usage:"Password" default:"hunter2" secret:"true"`
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{Password: "current"}) })
+	expected := `     --version string   Version (default 0)
+     --retries int      Retries
+     --ratio float64    Ratio
+     --enabled bool     Enabled
+     --password string  Password
+`
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestWideNumericTypes(t *testing.T) {
+	type Config struct {
+		Z     complex128
+		Big   *big.Int
+		Ratio *big.Float
+	}
+
+	var config Config
+	_, flags := ParseArgs([]string{"--z=3+4i", "--big=123456789012345678901234567890", "--ratio=1.5"})
+	if err := SetFlags(&config, flags); err != nil {
+		t.Fatalf("SetFlags failed: %v", err)
+	}
+	if config.Z != complex(3, 4) {
+		t.Errorf("Expected z 3+4i, got %v", config.Z)
+	}
+	if config.Big == nil || config.Big.String() != "123456789012345678901234567890" {
+		t.Errorf("Expected big 123456789012345678901234567890, got %v", config.Big)
+	}
+	if config.Ratio == nil || config.Ratio.String() != "1.5" {
+		t.Errorf("Expected ratio 1.5, got %v", config.Ratio)
+	}
+
+	errorCases := map[string]string{
+		"--z=3+":     "invalid complex128 value \"3+\"",
+		"--big=12x":  "invalid big.Int value \"12x\"",
+		"--ratio=1e": "invalid big.Float value \"1e\"",
+	}
+	for arg, expectedErrorMessage := range errorCases {
+		_, flags := ParseArgs([]string{arg})
+		err := SetFlags(&config, flags)
+		if err == nil || !strings.Contains(err.Error(), expectedErrorMessage) {
+			t.Errorf("Expected error containing '%s' for %s, got %v", expectedErrorMessage, arg, err)
+		}
+	}
+}
+
+func TestSetFlagsPassthrough(t *testing.T) {
+	type Config struct {
+		Verbose bool `short:"v"`
+		Name    string
+	}
+
+	args := []string{"-v", "--name=app", "--unknown=x", "--debug", "-u", "y", "-q", "-o=-1", "--empty="}
+	_, flags := ParseArgs(args)
+
+	var config Config
+	forwarded, err := SetFlagsPassthrough(&config, flags)
+	if err != nil {
+		t.Fatalf("SetFlagsPassthrough failed: %v", err)
+	}
+	if !config.Verbose || config.Name != "app" {
+		t.Errorf("Expected known flags to be set, got %+v", config)
+	}
+
+	expected := []string{"--debug", "--empty", "-o=-1", "-q", "-u", "y", "--unknown=x"}
+	if !reflect.DeepEqual(forwarded, expected) {
+		t.Errorf("Expected forwarded %q, got %q", expected, forwarded)
+	}
+
+	// The forwarded flags parse back into the unknown flags
+	_, reparsed := ParseArgs(forwarded)
+	delete(flags, "v")
+	delete(flags, "name")
+	if !reflect.DeepEqual(reparsed, flags) {
+		t.Errorf("Expected forwarded flags to round-trip to %v, got %v", flags, reparsed)
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	type Config struct {
+		Name    string `unquote:"true"`
+		Literal string
+	}
+
+	testCases := []struct {
+		value     string
+		expected  string
+		expectErr bool
+	}{
+		{`"John Doe"`, "John Doe", false},
+		{`'John Doe'`, "John Doe", false},
+		{`"say \"hi\""`, `say "hi"`, false},
+		{`'a\n'`, `a\n`, false},
+		{`plain`, "plain", false},
+		{`it's`, "it's", false},
+		{`"unterminated`, "", true},
+		{`'`, "", true},
+	}
+
+	for _, tc := range testCases {
+		var config Config
+		err := SetFlags(&config, map[string]string{"name": tc.value, "literal": tc.value})
+		if (err != nil) != tc.expectErr {
+			t.Errorf("SetFlags(%s) error = %v, expectErr %v", tc.value, err, tc.expectErr)
+			continue
+		}
+		if tc.expectErr {
+			continue
+		}
+		if config.Name != tc.expected {
+			t.Errorf("Expected unquoted '%s' for %s, got '%s'", tc.expected, tc.value, config.Name)
+		}
+		if config.Literal != tc.value {
+			t.Errorf("Expected untagged field to keep '%s', got '%s'", tc.value, config.Literal)
+		}
+	}
+}
+
+func TestParseAllContext(t *testing.T) {
+	type Config struct {
+		PortNumber int `default:"8080"`
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var config Config
+	_, _, err := ParseAllContext(ctx, &config, []string{"--port-number=9090"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if config.PortNumber != 0 {
+		t.Errorf("Expected no stage to run, got port %d", config.PortNumber)
+	}
+
+	_, _, err = ParseAllContext(context.Background(), &config, []string{"--port-number=9090"})
+	if err != nil {
+		t.Fatalf("ParseAllContext failed: %v", err)
+	}
+	if config.PortNumber != 9090 {
+		t.Errorf("Expected port 9090, got %d", config.PortNumber)
+	}
+}
+
+type LogLevel int
+
+func (l LogLevel) String() string {
+	switch l {
+	case 1:
+		return "debug"
+	case 2:
+		return "info"
+	case 3:
+		return "warn"
+	}
+	return "unknown"
+}
+
+func TestPrintDefaultsStringer(t *testing.T) {
+	type Config struct {
+		Level LogLevel `usage:"Log level" default:"2"`
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "     --level LogLevel  Log level (default info)\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestValidateNames(t *testing.T) {
+	type Config struct {
+		PortNumber int    `short:"p"`
+		Path       string `short:"p"`
+		Port       int    `flag:"port-number"`
+		Host       string `env:"APP_HOST"`
+		Hostname   string `env:"APP_HOST"`
+	}
+
+	err := ValidateNames(&Config{})
+	if err == nil {
+		t.Fatal("Expected error, got none")
+	}
+	expected := []string{
+		"duplicate flag -p for fields PortNumber and Path",
+		"duplicate flag --port-number for fields PortNumber and Port",
+		"duplicate environment variable APP_HOST for fields Host and Hostname",
+	}
+	for _, message := range expected {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error to contain '%s', got '%s'", message, err.Error())
+		}
+	}
+
+	if _, _, err := ParseAll(&Config{}, nil); err == nil {
+		t.Error("Expected ParseAll to report duplicate names")
+	}
+
+	type Valid struct {
+		PortNumber int `short:"p"`
+		Verbose    bool
+	}
+	if err := ValidateNames(&Valid{}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	type Mixed struct {
+		Profile string `flag:"p"`
+		Path    string `short:"p"`
+	}
+	err = ValidateNames(&Mixed{})
+	if err == nil || !strings.Contains(err.Error(), "duplicate flag -p for fields Profile and Path") {
+		t.Errorf("Expected duplicate -p error, got %v", err)
+	}
+
+	// Names are only compared where the fields are read from
+	type Separate struct {
+		Token     string `source:"env"`
+		TokenFlag string `flag:"token" env:"TOKEN" source:"flag"`
+	}
+	var separate Separate
+	if _, _, err := ParseAll(&separate, []string{"--token=x"}); err != nil || separate.TokenFlag != "x" {
+		t.Errorf("Expected --token to set TokenFlag, got %+v, %v", separate, err)
+	}
+}
+
+func TestBoolShortFlags(t *testing.T) {
+	type Config struct {
+		Verbose bool `short:"v" default:"true"`
+		Quiet   bool `short:"q"`
+		Force   bool `short:"f"`
+	}
+
+	testCases := []struct {
+		args    []string
+		verbose bool
+		quiet   bool
+		force   bool
+	}{
+		{[]string{"-v=false"}, false, false, false},
+		{[]string{"-q"}, true, true, false},
+		{[]string{"-vq"}, true, true, false},
+		{[]string{"-qf"}, true, true, true},
+		{[]string{"-qv=false"}, false, true, false},
+		{[]string{"-q=true", "-f=0"}, true, true, false},
+	}
+
+	for _, tc := range testCases {
+		var config Config
+		if _, _, err := ParseAll(&config, tc.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tc.args, err)
+		}
+		if config.Verbose != tc.verbose || config.Quiet != tc.quiet || config.Force != tc.force {
+			t.Errorf("ParseAll(%v) got verbose=%v quiet=%v force=%v, want %v %v %v",
+				tc.args, config.Verbose, config.Quiet, config.Force, tc.verbose, tc.quiet, tc.force)
+		}
+	}
+}
+
+func TestBoolFlagsKeepPositionals(t *testing.T) {
+	type Config struct {
+		Verbose bool  `short:"v"`
+		DryRun  *bool `short:"n"`
+	}
+
+	var config Config
+	args, _, err := ParseAll(&config, []string{"--verbose", "cmd", "-n", "file", "--verbose=false"})
+	if err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(args, []string{"cmd", "file"}) {
+		t.Errorf("Expected positionals [cmd file], got %v", args)
+	}
+	if config.Verbose || config.DryRun == nil || !*config.DryRun {
+		t.Errorf("Expected verbose=false and dry-run=true, got %v and %v", config.Verbose, config.DryRun)
+	}
+}
+
+func TestSetFlagsOrdered(t *testing.T) {
+	type Config struct {
+		Level string   `short:"l"`
+		Tags  []string `short:"t"`
+	}
+
+	var config Config
+	_, flags := ParseArgsOrdered([]string{"--level=info", "--tags", "a,b", "-l", "debug", "-t", "c"}, ArgSpec{})
+	if err := SetFlagsOrdered(&config, flags); err != nil {
+		t.Fatalf("SetFlagsOrdered failed: %v", err)
+	}
+	if config.Level != "debug" {
+		t.Errorf("Expected last level 'debug', got '%s'", config.Level)
+	}
+	if !reflect.DeepEqual(config.Tags, []string{"a", "b", "c"}) {
+		t.Errorf("Expected tags [a b c], got %v", config.Tags)
+	}
+
+	config = Config{Tags: []string{"env"}}
+	if _, _, err := ParseAll(&config, []string{"--tags=x", "--tags=y"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(config.Tags, []string{"x", "y"}) {
+		t.Errorf("Expected tags [x y], got %v", config.Tags)
+	}
+}
+
+func TestRepeatedValueSlice(t *testing.T) {
+	type Config struct {
+		Bind net.IP
+		Key  []byte
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--bind", "1.2.3.4", "--bind", "5.6.7.8", "--key=1,2", "--key=3"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !config.Bind.Equal(net.ParseIP("5.6.7.8")) {
+		t.Errorf("Expected the last IP 5.6.7.8, got %v", config.Bind)
+	}
+	if !reflect.DeepEqual(config.Key, []byte{3}) {
+		t.Errorf("Expected the last key [3], got %v", config.Key)
+	}
+}
+
+func TestSetEnvNameFunc(t *testing.T) {
+	type Config struct {
+		PortNumber int
+		HostName   string `env:"APP_HOST"`
+	}
+
+	SetEnvNameFunc(func(fieldName string) string {
+		return "app." + strings.ToLower(fieldName)
+	})
+	defer SetEnvNameFunc(nil)
+
+	os.Setenv("app.portnumber", "3000")
+	os.Setenv("PORT_NUMBER", "4000")
+	os.Setenv("APP_HOST", "example.com")
+	defer func() {
+		os.Unsetenv("app.portnumber")
+		os.Unsetenv("PORT_NUMBER")
+		os.Unsetenv("APP_HOST")
+	}()
+
+	var config Config
+	if err := ParseEnv(&config); err != nil {
+		t.Fatalf("ParseEnv failed: %v", err)
+	}
+	if config.PortNumber != 3000 {
+		t.Errorf("Expected port 3000 from app.portnumber, got %d", config.PortNumber)
+	}
+	if config.HostName != "example.com" {
+		t.Errorf("Expected env tag to win over the name function, got '%s'", config.HostName)
+	}
+}
+
+func TestSetFlagNameFunc(t *testing.T) {
+	type Config struct {
+		PortNumber int    `usage:"Port to listen on"`
+		HostName   string `flag:"host" usage:"Host address"`
+	}
+
+	SetFlagNameFunc(words.ToSnakeCase)
+	defer SetFlagNameFunc(nil)
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--port_number=9090", "--host=example.com"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.PortNumber != 9090 {
+		t.Errorf("Expected port 9090 from --port_number, got %d", config.PortNumber)
+	}
+	if config.HostName != "example.com" {
+		t.Errorf("Expected flag tag to win over the name function, got '%s'", config.HostName)
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := `     --port_number int  Port to listen on
+     --host string      Host address
+`
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	type Config struct {
+		PortNumber int    `usage:"Port to listen on" short:"p" default:"8080" group:"Server"`
+		HostName   string `usage:"Host address" default:"localhost" required:"true"`
+		Verbose    bool   `usage:"Verbose mode" short:"v"`
+		Timeout    *int   `usage:"Timeout in seconds" short:"t"`
+		Token      string `usage:"API token" default:"abc" secret:"true"`
+	}
+
+	expected := []FlagInfo{
+		{Name: "port-number", Short: "p", Type: "int", Default: "8080", Usage: "Port to listen on", Group: "Server"},
+		{Name: "host-name", Type: "string", Default: "localhost", Usage: "Host address", Required: true},
+		{Name: "verbose", Short: "v", Type: "bool", Usage: "Verbose mode"},
+		{Name: "timeout", Short: "t", Type: "*int", Usage: "Timeout in seconds"},
+		{Name: "token", Type: "string", Usage: "API token"},
+	}
+	infos := Describe(&Config{})
+	if !reflect.DeepEqual(infos, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, infos)
+	}
+}
+
+func TestFormatDefaultsMarkdown(t *testing.T) {
+	type Config struct {
+		PortNumber int    `usage:"Port to listen on" short:"p" default:"8080"`
+		Format     string `usage:"Output format: json|text" default:"text"`
+		Verbose    bool   `usage:"Verbose mode" short:"v,d"`
+		Token      string `usage:"API token" default:"abc" secret:"true"`
+		Internal   string `flag:"-"`
+	}
+
+	expected := "| Flag | Short | Type | Default | Description |\n" +
+		"|---|---|---|---|---|\n" +
+		"| `--port-number` | `-p` | int | `8080` | Port to listen on |\n" +
+		"| `--format` |  | string | `text` | Output format: json\\|text |\n" +
+		"| `--verbose` | `-v`, `-d` | bool |  | Verbose mode |\n" +
+		"| `--token` |  | string |  | API token |\n"
+	output := FormatDefaultsMarkdown(&Config{})
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestTimeFields(t *testing.T) {
+	type Config struct {
+		Start time.Time `default:"2024-01-02T15:04:05Z"`
+		Date  time.Time `layout:"2006-01-02"`
+		At    time.Time `allow_now:"true"`
+	}
+
+	var config Config
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if !config.Start.Equal(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("Expected start 2024-01-02T15:04:05Z, got %v", config.Start)
+	}
+
+	before := time.Now()
+	_, flags := ParseArgs([]string{"--date=2024-03-15", "--at=now"})
+	if err := SetFlags(&config, flags); err != nil {
+		t.Fatalf("SetFlags failed: %v", err)
+	}
+	if !config.Date.Equal(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected date 2024-03-15, got %v", config.Date)
+	}
+	if config.At.Before(before) || config.At.After(time.Now()) {
+		t.Errorf("Expected at to resolve to now, got %v", config.At)
+	}
+
+	errorCases := map[string]string{
+		"--date=15/03/2024": "invalid time \"15/03/2024\", expected layout 2006-01-02",
+		"--start=now":       "invalid time \"now\", expected layout " + time.RFC3339,
+	}
+	for arg, expectedErrorMessage := range errorCases {
+		_, flags := ParseArgs([]string{arg})
+		err := SetFlags(&config, flags)
+		if err == nil || !strings.Contains(err.Error(), expectedErrorMessage) {
+			t.Errorf("Expected error containing '%s' for %s, got %v", expectedErrorMessage, arg, err)
+		}
+	}
+}
+
+func TestParseEnvFrom(t *testing.T) {
+	type Config struct {
+		PortNumber int    `env:"PORT"`
+		HostName   string `default:"localhost"`
+		Tags       []string
+	}
+
+	env := map[string]string{"PORT": "3000", "TAGS": "a,b"}
+
+	var config Config
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if err := ParseEnvFrom(&config, env); err != nil {
+		t.Fatalf("ParseEnvFrom failed: %v", err)
+	}
+	if config.PortNumber != 3000 {
+		t.Errorf("Expected port 3000, got %d", config.PortNumber)
+	}
+	if config.HostName != "localhost" {
+		t.Errorf("Expected default host 'localhost', got '%s'", config.HostName)
+	}
+	if !reflect.DeepEqual(config.Tags, []string{"a", "b"}) {
+		t.Errorf("Expected tags [a b], got %v", config.Tags)
+	}
+
+	err := ParseEnvFrom(&config, map[string]string{"PORT": "x"})
+	if err == nil || !strings.Contains(err.Error(), "error setting environment variable PORT") {
+		t.Errorf("Expected error for invalid PORT, got %v", err)
+	}
+}
+
+func TestImplicitValue(t *testing.T) {
+	type Config struct {
+		Color string `default:"never" implicit:"auto"`
+	}
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{}, "never"},
+		{[]string{"--color"}, "auto"},
+		{[]string{"--color", "--other"}, "auto"},
+		{[]string{"--color=always"}, "always"},
+		{[]string{"--color", "always"}, "always"},
+		{[]string{"--color="}, ""},
+	}
+
+	for _, tc := range testCases {
+		var config Config
+		if _, _, err := ParseAll(&config, tc.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tc.args, err)
+		}
+		if config.Color != tc.expected {
+			t.Errorf("ParseAll(%v) got color '%s', want '%s'", tc.args, config.Color, tc.expected)
+		}
+	}
+
+	_, flags := ParseArgsOrdered([]string{"--color", "--color="}, ArgSpec{})
+	if flags[0].HasValue || !flags[1].HasValue {
+		t.Errorf("Expected --color without and --color= with a value, got %+v", flags)
+	}
+}
+
+func TestConfigByValue(t *testing.T) {
+	type Config struct {
+		Port int `default:"8080"`
+	}
+
+	var config Config
+	expected := "config must be a pointer to a struct, got flag_test.Config"
+	if err := SetDefaults(config); err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s', got %v", expected, err)
+	}
+	if _, _, err := ParseAll(config, []string{"--port", "80"}); err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected ParseAll error containing '%s', got %v", expected, err)
+	}
+	if err := SetFlags((*Config)(nil), map[string]string{}); err == nil {
+		t.Error("Expected an error for a nil config pointer")
+	}
+}
+
+type Level int
+
+func (l *Level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "info":
+		*l = 1
+	case "warn":
+		*l = 2
+	case "error":
+		*l = 3
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+	return nil
+}
+
+func TestTextUnmarshalerSlices(t *testing.T) {
+	type Config struct {
+		Level  Level
+		Levels []Level
+		Ptr    *Level
+	}
+
+	var config Config
+	flags := map[string]string{"level": "warn", "levels": "info,warn,error", "ptr": "error"}
+	if err := SetFlags(&config, flags); err != nil {
+		t.Fatalf("SetFlags failed: %v", err)
+	}
+	if config.Level != 2 {
+		t.Errorf("Expected level 2, got %d", config.Level)
+	}
+	if !reflect.DeepEqual(config.Levels, []Level{1, 2, 3}) {
+		t.Errorf("Expected levels [1 2 3], got %v", config.Levels)
+	}
+	if config.Ptr == nil || *config.Ptr != 3 {
+		t.Errorf("Expected ptr level 3, got %v", config.Ptr)
+	}
+
+	err := SetFlags(&config, map[string]string{"levels": "info,loud"})
+	if err == nil || !strings.Contains(err.Error(), `element 1: unknown level "loud"`) {
+		t.Errorf("Expected error for element 1, got %v", err)
+	}
+}
+
+func TestFlagRelations(t *testing.T) {
+	type Config struct {
+		Quiet   bool   `exclusive:"output"`
+		Verbose bool   `exclusive:"output"`
+		Debug   bool   `exclusive:"output"`
+		TLSKey  string `flag:"tls-key" requires:"tls-cert"`
+		TLSCert string `flag:"tls-cert"`
+	}
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--quiet"}, ""},
+		{[]string{"--tls-key", "k", "--tls-cert", "c"}, ""},
+		{[]string{"--quiet", "--verbose", "--debug"}, "flags --quiet, --verbose, --debug can't be used together"},
+		{[]string{"--tls-key", "k"}, "flag --tls-key requires --tls-cert"},
+	}
+
+	for _, tc := range testCases {
+		var config Config
+		_, _, err := ParseAll(&config, tc.args)
+		if tc.expected == "" {
+			if err != nil {
+				t.Errorf("ParseAll(%v) failed: %v", tc.args, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("ParseAll(%v) expected error containing '%s', got %v", tc.args, tc.expected, err)
+		}
+	}
+}
+
+func TestRegisterParser(t *testing.T) {
+	// A type from another package that can't get an UnmarshalText method
+	type Point struct{ X, Y int }
+	type Config struct {
+		Origin Point
+	}
+
+	unregister := RegisterParser(reflect.TypeOf(Point{}), func(s string) (interface{}, error) {
+		var p Point
+		if _, err := fmt.Sscanf(s, "%d:%d", &p.X, &p.Y); err != nil {
+			return nil, fmt.Errorf("invalid point %q", s)
+		}
+		return p, nil
+	})
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--origin", "3:4"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Origin != (Point{3, 4}) {
+		t.Errorf("Expected origin {3 4}, got %v", config.Origin)
+	}
+	if _, _, err := ParseAll(&config, []string{"--origin", "3"}); err == nil || !strings.Contains(err.Error(), `invalid point "3"`) {
+		t.Errorf("Expected invalid point error, got %v", err)
+	}
+
+	unregisterString := RegisterParser(reflect.TypeOf(Point{}), func(s string) (interface{}, error) {
+		return s, nil
+	})
+	if _, _, err := ParseAll(&config, []string{"--origin", "3:4"}); err == nil || !strings.Contains(err.Error(), "returned string") {
+		t.Errorf("Expected type mismatch error, got %v", err)
+	}
+
+	unregisterString()
+	if _, _, err := ParseAll(&config, []string{"--origin", "5:6"}); err != nil || config.Origin != (Point{5, 6}) {
+		t.Errorf("Expected the previous parser to be restored, got %v, %v", config.Origin, err)
+	}
+	unregister()
+	if _, _, err := ParseAll(&config, []string{"--origin", "7:8"}); err != nil || config.Origin != (Point{5, 6}) {
+		t.Errorf("Expected --origin to be ignored without a parser, got %v, %v", config.Origin, err)
+	}
+}
+
+func TestPrintDefaultsColor(t *testing.T) {
+	type Config struct {
+		PortNumber int    `usage:"Port" short:"p" default:"8080"`
+		HostName   string `usage:"Host"`
+	}
+
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(nil)
+
+	PrintDefaults(&Config{})
+	plain := buf.String()
+	expected := "  -p --port-number int   Port (default 8080)\n" +
+		"     --host-name string  Host\n"
+	if plain != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, plain)
+	}
+
+	SetColor(true)
+	defer ResetColor()
+
+	buf.Reset()
+	PrintDefaults(&Config{})
+	expected = "  \x1b[36m-p\x1b[0m \x1b[36m--port-number int\x1b[0m   Port \x1b[2m(default 8080)\x1b[0m\n" +
+		"     \x1b[36m--host-name string\x1b[0m  Host\n"
+	if buf.String() != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%q\nActual:\n%q", expected, buf.String())
+	}
+}
+
+func TestByteSizes(t *testing.T) {
+	type Config struct {
+		MaxUpload int64  `unit:"bytes"`
+		Buffer    uint32 `unit:"bytes"`
+	}
+
+	testCases := []struct {
+		value    string
+		expected int64
+	}{
+		{"1024", 1024},
+		{"10MB", 10_000_000},
+		{"1GiB", 1 << 30},
+		{"1.5KiB", 1536},
+		{"2 kb", 2000},
+	}
+
+	for _, tc := range testCases {
+		var config Config
+		if err := SetFlags(&config, map[string]string{"max-upload": tc.value}); err != nil {
+			t.Fatalf("SetFlags(%s) failed: %v", tc.value, err)
+		}
+		if config.MaxUpload != tc.expected {
+			t.Errorf("SetFlags(%s) got %d, want %d", tc.value, config.MaxUpload, tc.expected)
+		}
+	}
+
+	var config Config
+	if err := SetFlags(&config, map[string]string{"max-upload": "10XB"}); err == nil || !strings.Contains(err.Error(), `invalid size "10XB"`) {
+		t.Errorf("Expected invalid size error, got %v", err)
+	}
+	if err := SetFlags(&config, map[string]string{"buffer": "8GB"}); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("Expected out of range error, got %v", err)
+	}
+}
+
+func TestRequirePositional(t *testing.T) {
+	type Config struct {
+		Verbose bool
+	}
+	defer RequirePositional(0, -1)
+
+	testCases := []struct {
+		min, max int
+		args     []string
+		expected string
+	}{
+		{1, 2, []string{}, "expected between 1 and 2 arguments, got 0"},
+		{1, 2, []string{"a", "--verbose"}, ""},
+		{1, 2, []string{"a", "b"}, ""},
+		{1, 2, []string{"a", "b", "c"}, "expected between 1 and 2 arguments, got 3"},
+		{1, 1, []string{"a", "b"}, "expected 1 argument, got 2"},
+		{2, -1, []string{"a"}, "expected at least 2 arguments, got 1"},
+		{2, -1, []string{"a", "b", "c", "d"}, ""},
+		{0, 0, []string{"a"}, "expected 0 arguments, got 1"},
+	}
+
+	for _, tc := range testCases {
+		RequirePositional(tc.min, tc.max)
+		var config Config
+		_, _, err := ParseAll(&config, tc.args)
+		if tc.expected == "" {
+			if err != nil {
+				t.Errorf("RequirePositional(%d, %d) with %v failed: %v", tc.min, tc.max, tc.args, err)
+			}
+		} else if err == nil || err.Error() != tc.expected {
+			t.Errorf("RequirePositional(%d, %d) with %v expected error '%s', got %v", tc.min, tc.max, tc.args, tc.expected, err)
+		}
+	}
+}
+
+func TestPromptRequired(t *testing.T) {
+	type Config struct {
+		DatabaseURL string `prompt:"Enter database URL:" required:"true"`
+		Password    string `prompt:"Password:" secret:"true"`
+		Name        string `required:"true" default:"app"`
+	}
+
+	var out bytes.Buffer
+	SetPromptIO(strings.NewReader("postgres://db\nhunter2\n"), &out)
+	defer SetPromptIO(nil, nil)
+
+	var config Config
+	_, _, sources, err := ParseAllSources(&config, []string{})
+	if err != nil {
+		t.Fatalf("ParseAllSources failed: %v", err)
+	}
+	if config.DatabaseURL != "postgres://db" || config.Password != "hunter2" {
+		t.Errorf("Expected prompted values, got '%s' and '%s'", config.DatabaseURL, config.Password)
+	}
+	if sources["DatabaseURL"] != SourcePrompt {
+		t.Errorf("Expected source prompt, got %s", sources["DatabaseURL"])
+	}
+	if out.String() != "Enter database URL: Password: " {
+		t.Errorf("Unexpected prompt output '%s'", out.String())
+	}
+
+	// Flags are not prompted for
+	config = Config{}
+	SetPromptIO(strings.NewReader(""), &out)
+	if _, _, err := ParseAll(&config, []string{"--database-url", "x"}); err != nil {
+		t.Errorf("ParseAll failed: %v", err)
+	}
+
+	// Without a terminal on stdin the required error is returned
+	SetPromptIO(nil, nil)
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	w.Close()
+	originalStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = originalStdin }()
+
+	config = Config{}
+	_, _, err = ParseAll(&config, []string{})
+	if err == nil || !strings.Contains(err.Error(), "flag --database-url is required") {
+		t.Errorf("Expected required error, got %v", err)
+	}
+}
+
+func TestPromptExplicitZero(t *testing.T) {
+	type Config struct {
+		Retries int    `prompt:"Retries:"`
+		Name    string `prompt:"Name:"`
+		Count   int    `required:"true"`
+	}
+
+	var out bytes.Buffer
+	in := strings.NewReader("app\nrest of stdin\n")
+	SetPromptIO(in, &out)
+	defer SetPromptIO(nil, nil)
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--retries=0", "--count=0"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Retries != 0 || config.Name != "app" || out.String() != "Name: " {
+		t.Errorf("Expected only name to be prompted for, got %+v and output %q", config, out.String())
+	}
+	if rest, _ := io.ReadAll(in); string(rest) != "rest of stdin\n" {
+		t.Errorf("Expected the input after the answer to be left unread, got %q", rest)
+	}
+}
+
+func TestAllowAbbrev(t *testing.T) {
+	type Config struct {
+		Verbose bool
+		Port    int
+		Pool    int
+		Po      string
+	}
+
+	var config Config
+	if err := SetFlags(&config, map[string]string{"verb": ""}); err != nil {
+		t.Fatalf("SetFlags failed: %v", err)
+	}
+	if config.Verbose {
+		t.Error("Expected --verb to be ignored without SetAllowAbbrev")
+	}
+
+	SetAllowAbbrev(true)
+	defer SetAllowAbbrev(false)
+
+	config = Config{}
+	if _, _, err := ParseAll(&config, []string{"--verb", "--por", "80", "--po", "x"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !config.Verbose || config.Port != 80 || config.Po != "x" {
+		t.Errorf("Expected verbose, port 80 and po x, got %+v", config)
+	}
+
+	type Ambiguous struct {
+		Port int
+		Pool int
+	}
+	var ambiguous Ambiguous
+	_, _, err := ParseAll(&ambiguous, []string{"--po", "1"})
+	if err == nil || !strings.Contains(err.Error(), "--po is ambiguous: --port, --pool") {
+		t.Errorf("Expected ambiguous error, got %v", err)
+	}
+
+	// Fields that flags can't set are no candidates
+	type Sourced struct {
+		Section string
+		Secret  string `source:"env"`
+		Script  string `arg:"script"`
+	}
+	var sourced Sourced
+	if _, _, err := ParseAll(&sourced, []string{"--sec=a", "--scr=b"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if sourced != (Sourced{Section: "a"}) {
+		t.Errorf("Expected only section a to be set, got %+v", sourced)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	type Config struct {
+		Level string   `normalize:"lower"`
+		Name  string   `normalize:"trim"`
+		Dir   string   `normalize:"cleanpath"`
+		Mode  string   `normalize:"trim,lower"`
+		Tags  []string `normalize:"upper"`
+		Bad   string   `normalize:"reverse"`
+	}
+
+	var config Config
+	flags := map[string]string{
+		"level": "INFO",
+		"name":  "  app ",
+		"dir":   "a/b/../c/",
+		"mode":  " Fast ",
+		"tags":  "x,y",
+	}
+	if err := SetFlags(&config, flags); err != nil {
+		t.Fatalf("SetFlags failed: %v", err)
+	}
+	expected := Config{Level: "info", Name: "app", Dir: filepath.Clean("a/c"), Mode: "fast", Tags: []string{"X", "Y"}}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, config)
+	}
+
+	err := SetFlags(&config, map[string]string{"bad": "x"})
+	if err == nil || !strings.Contains(err.Error(), `unknown normalizer "reverse"`) {
+		t.Errorf("Expected unknown normalizer error, got %v", err)
+	}
+}
+
+func TestMapFlags(t *testing.T) {
+	type Config struct {
+		Label  map[string]string `short:"l" default:"env=dev"`
+		Limits map[string]int
+	}
+
+	var config Config
+	args := []string{"--label", "a=1", "--label=b=2", "-l", "c=3,a=4", "--limits", "cpu=2,mem=0x10"}
+	if _, _, err := ParseAll(&config, args); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	expectedLabels := map[string]string{"a": "4", "b": "2", "c": "3"}
+	if !reflect.DeepEqual(config.Label, expectedLabels) {
+		t.Errorf("Expected labels %v, got %v", expectedLabels, config.Label)
+	}
+	expectedLimits := map[string]int{"cpu": 2, "mem": 16}
+	if !reflect.DeepEqual(config.Limits, expectedLimits) {
+		t.Errorf("Expected limits %v, got %v", expectedLimits, config.Limits)
+	}
+
+	config = Config{}
+	if _, _, err := ParseAll(&config, []string{}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(config.Label, map[string]string{"env": "dev"}) {
+		t.Errorf("Expected default labels, got %v", config.Label)
+	}
+
+	err := SetFlags(&config, map[string]string{"limits": "cpu"})
+	if err == nil || !strings.Contains(err.Error(), `invalid map entry "cpu", expected key=value`) {
+		t.Errorf("Expected invalid map entry error, got %v", err)
+	}
+}
+
+func TestSetDefaultsFrom(t *testing.T) {
+	type Upstream struct {
+		Host string
+		Port int
+	}
+	type Config struct {
+		Host      string `default:"localhost"`
+		Port      int    `default:"8080"`
+		Upstreams []Upstream
+		Verbose   bool
+	}
+
+	defaults := Config{
+		Host:      "example.com",
+		Upstreams: []Upstream{{"a", 1}, {"b", 2}},
+	}
+
+	var config Config
+	if err := SetDefaultsFrom(&config, defaults); err != nil {
+		t.Fatalf("SetDefaultsFrom failed: %v", err)
+	}
+	if err := SetFlags(&config, map[string]string{"host": "flag.example.com"}); err != nil {
+		t.Fatalf("SetFlags failed: %v", err)
+	}
+
+	expected := Config{
+		Host:      "flag.example.com",
+		Port:      8080,
+		Upstreams: []Upstream{{"a", 1}, {"b", 2}},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, config)
+	}
+
+	config.Upstreams[0].Host = "changed"
+	if defaults.Upstreams[0].Host != "a" {
+		t.Error("Expected the defaults to be copied")
+	}
+
+	type Other struct {
+		Host string
+		Port string
+	}
+	var unchanged Config
+	err := SetDefaultsFrom(&unchanged, Other{Host: "other.example.com", Port: "80"})
+	if err == nil || !strings.Contains(err.Error(), "default for field Port has type string, expected int") {
+		t.Errorf("Expected type mismatch error, got %v", err)
+	}
+	if !reflect.DeepEqual(unchanged, Config{}) {
+		t.Errorf("Expected config to be unchanged on a type mismatch, got %+v", unchanged)
+	}
+}
+
+type upstreamConfig struct {
+	Host      string   `default:"localhost"`
+	Port      int      `default:"8080"`
+	Upstreams []string `default:"tag"`
+}
+
+func (c *upstreamConfig) Defaults() interface{} {
+	return upstreamConfig{Host: "example.com", Upstreams: []string{"a", "b"}}
+}
+
+func TestDefaultsProvider(t *testing.T) {
+	var config upstreamConfig
+	result, err := NewFlagSet([]string{"--port=9090"}, io.Discard, map[string]string{"UPSTREAMS": "env"}).ParseResult(&config)
+	if err != nil {
+		t.Fatalf("ParseResult failed: %v", err)
+	}
+	expected := upstreamConfig{Host: "example.com", Port: 9090, Upstreams: []string{"env"}}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, config)
+	}
+	if result.Sources["Host"] != SourceDefault {
+		t.Errorf("Expected Host from the default source, got %v", result.Sources["Host"])
+	}
+}
+
+func TestFatal(t *testing.T) {
+	type Config struct {
+		Timeout int `usage:"Timeout in seconds"`
+	}
+
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(nil)
+
+	code := -1
+	defer SetExit(func(c int) { code = c })()
+
+	var config Config
+	_, _, err := ParseAll(&config, []string{"--timeout=thirty"})
+	Fatal(&config, err)
+
+	if code != 2 {
+		t.Errorf("Expected exit code 2, got %d", code)
+	}
+	output := buf.String()
+	if !strings.HasPrefix(output, "Error: error parsing command-line arguments: error parsing flag --timeout") {
+		t.Errorf("Expected the error to be printed, got '%s'", output)
+	}
+	if !strings.Contains(output, "Usage:\n     --timeout int  Timeout in seconds\n") {
+		t.Errorf("Expected the usage to be printed, got '%s'", output)
+	}
+
+	buf.Reset()
+	_, _, err = ParseAll(&config, []string{"--help"})
+	Fatal(&config, err)
+	if code != 0 {
+		t.Errorf("Expected exit code 0 for help, got %d", code)
+	}
+
+	code = -1
+	Fatal(&config, nil)
+	if code != -1 {
+		t.Errorf("Expected no exit without an error, got %d", code)
+	}
+}
+
+func TestSetAutoHelp(t *testing.T) {
+	type Config struct {
+		Host string `short:"h"`
+		Help bool
+	}
+
+	SetAutoHelp(false)
+	defer SetAutoHelp(true)
+
+	var config Config
+	output := captureStdout(func() {
+		if _, _, err := ParseAll(&config, []string{"-h", "myhost", "--help"}); err != nil {
+			t.Errorf("ParseAll failed: %v", err)
+		}
+	})
+	if output != "" {
+		t.Errorf("Expected no help output, got '%s'", output)
+	}
+	if config.Host != "myhost" || !config.Help {
+		t.Errorf("Expected host myhost and help set, got %+v", config)
+	}
+}
+
+func TestSetStrict(t *testing.T) {
+	type Config struct {
+		All     bool `short:"a"`
+		Verbose bool `short:"b"`
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"-abx", "--other"}); err != nil {
+		t.Fatalf("Expected unknown flags to be ignored, got %v", err)
+	}
+
+	SetStrict(true)
+	defer SetStrict(false)
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-ab"}, ""},
+		{[]string{"-abx"}, `unknown flag -x at position 3 in "-abx"`},
+		{[]string{"-xab"}, `unknown flag -x at position 1 in "-xab"`},
+		{[]string{"-a", "-x"}, "unknown flag -x"},
+		{[]string{"--all", "--other=1"}, "unknown flag --other"},
+	}
+
+	for _, tc := range testCases {
+		config = Config{}
+		_, _, err := ParseAll(&config, tc.args)
+		if tc.expected == "" {
+			if err != nil {
+				t.Errorf("ParseAll(%v) failed: %v", tc.args, err)
+			}
+		} else if err == nil || !strings.HasSuffix(err.Error(), tc.expected) {
+			t.Errorf("ParseAll(%v) expected error ending in '%s', got %v", tc.args, tc.expected, err)
+		}
+	}
+}
+
+func TestParseHooks(t *testing.T) {
+	type Config struct {
+		Port int
+	}
+	defer ResetHooks()
+
+	var calls []string
+	var gotArgs []string
+	var gotConfig interface{}
+	var gotErr error
+	RegisterPreParse(func(args []string) {
+		calls = append(calls, "pre")
+		gotArgs = args
+	})
+	RegisterPreParse(func(args []string) { panic("broken hook") })
+	RegisterPostParse(func(config interface{}, err error) {
+		calls = append(calls, "post")
+		gotConfig, gotErr = config, err
+	})
+
+	var config Config
+	args := []string{"--port", "80"}
+	if _, _, err := ParseAll(&config, args); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"pre", "post"}) {
+		t.Errorf("Expected hooks pre and post, got %v", calls)
+	}
+	if !reflect.DeepEqual(gotArgs, args) || gotConfig != &config || gotErr != nil {
+		t.Errorf("Unexpected hook arguments %v, %v, %v", gotArgs, gotConfig, gotErr)
+	}
+	if config.Port != 80 {
+		t.Errorf("Expected port 80 despite the panicking hook, got %d", config.Port)
+	}
+
+	_, _, err := ParseAll(&config, []string{"--port", "x"})
+	if err == nil || gotErr != err {
+		t.Errorf("Expected post hook to get error %v, got %v", err, gotErr)
+	}
+}
+
+func TestTriStateBool(t *testing.T) {
+	type Config struct {
+		DryRun  *bool `short:"d"`
+		Verbose bool  `short:"v"`
+		Limit   *int
+	}
+
+	testCases := []struct {
+		args     []string
+		expected *bool
+	}{
+		{[]string{}, nil},
+		{[]string{"--dry-run"}, boolPtr(true)},
+		{[]string{"--dry-run=false"}, boolPtr(false)},
+		{[]string{"-dv"}, boolPtr(true)},
+	}
+
+	for _, tc := range testCases {
+		var config Config
+		if _, _, err := ParseAll(&config, tc.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tc.args, err)
+		}
+		if !reflect.DeepEqual(config.DryRun, tc.expected) {
+			t.Errorf("ParseAll(%v) got dry run %v, want %v", tc.args, config.DryRun, tc.expected)
+		}
+		if config.Limit != nil {
+			t.Errorf("ParseAll(%v) expected limit to stay nil, got %d", tc.args, *config.Limit)
+		}
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--limit", "5"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Limit == nil || *config.Limit != 5 {
+		t.Errorf("Expected limit 5, got %v", config.Limit)
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestNullable(t *testing.T) {
+	type Config struct {
+		Proxy *string `default:"http://proxy:3128" nullable:"none"`
+	}
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{}, "http://proxy:3128"},
+		{[]string{"--proxy=none"}, ""},
+		{[]string{"--proxy=http://x"}, "http://x"},
+	}
+
+	for _, tc := range testCases {
+		var config Config
+		if _, _, err := ParseAll(&config, tc.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tc.args, err)
+		}
+		if tc.expected == "" {
+			if config.Proxy != nil {
+				t.Errorf("ParseAll(%v) expected a nil proxy, got %q", tc.args, *config.Proxy)
+			}
+		} else if config.Proxy == nil || *config.Proxy != tc.expected {
+			t.Errorf("ParseAll(%v) got proxy %v, want %s", tc.args, config.Proxy, tc.expected)
+		}
+	}
+}
+
+func TestSourceTag(t *testing.T) {
+	type Config struct {
+		Secret string `source:"env" usage:"API secret"`
+		Host   string `source:"flag" usage:"Host name"`
+		Port   int    `usage:"Port"`
+	}
+
+	os.Setenv("SECRET", "from-env")
+	os.Setenv("HOST", "env.example.com")
+	defer func() {
+		os.Unsetenv("SECRET")
+		os.Unsetenv("HOST")
+	}()
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--secret", "from-flag", "--host", "flag.example.com"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Secret != "from-env" {
+		t.Errorf("Expected secret from env, got '%s'", config.Secret)
+	}
+	if config.Host != "flag.example.com" {
+		t.Errorf("Expected host from flag, got '%s'", config.Host)
+	}
+
+	config = Config{}
+	if err := ParseEnv(&config); err != nil {
+		t.Fatalf("ParseEnv failed: %v", err)
+	}
+	if config.Host != "" {
+		t.Errorf("Expected host to ignore env, got '%s'", config.Host)
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "     --host string  Host name\n" +
+		"     --port int     Port\n" +
+		"\n" +
+		"Environment:\n" +
+		"  SECRET string  API secret\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestPrintDefaultsOrder(t *testing.T) {
+	type Config struct {
+		Verbose bool   `usage:"Verbose output"`
+		Port    int    `usage:"Port" order:"2"`
+		Host    string `usage:"Host" order:"1"`
+		Debug   bool   `usage:"Debug output"`
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "     --host string   Host\n" +
+		"     --port int      Port\n" +
+		"     --verbose bool  Verbose output\n" +
+		"     --debug bool    Debug output\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+
+	infos := Describe(&Config{})
+	if infos[0].Name != "host" || infos[3].Name != "debug" {
+		t.Errorf("Expected Describe in help order, got %+v", infos)
+	}
+}
+
+func TestConfigDumpFlag(t *testing.T) {
+	type Config struct {
+		Port     int    `default:"8080"`
+		Password string `secret:"true" required:"true"`
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--print-config"}); err == nil || !strings.Contains(err.Error(), "required") {
+		t.Errorf("Expected the dump flag to be disabled by default, got %v", err)
+	}
+
+	SetConfigDumpFlag("print-config")
+	defer SetConfigDumpFlag("")
+
+	config = Config{}
+	var err error
+	output := captureStdout(func() {
+		_, _, err = ParseAll(&config, []string{"--port", "9090", "--print-config", "--password", "x"})
+	})
+	if !errors.Is(err, ErrConfigDump) {
+		t.Errorf("Expected ErrConfigDump, got %v", err)
+	}
+	expected := "{\n  \"port\": 9090,\n  \"password\": \"***\"\n}\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestPositionalArgs(t *testing.T) {
+	type Config struct {
+		Verbose bool     `short:"v" usage:"Verbose output"`
+		Source  string   `arg:"source" usage:"File to copy"`
+		Dest    string   `arg:"dest" usage:"Destination directory"`
+		Extra   []string `arg:"files" usage:"More files to copy"`
+	}
+
+	var config Config
+	args, _, err := ParseAll(&config, []string{"a.txt", "out", "b,c.txt", "d.txt", "-v"})
+	if err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Source != "a.txt" || config.Dest != "out" || !config.Verbose {
+		t.Errorf("Expected source a.txt, dest out and verbose, got %+v", config)
+	}
+	if !reflect.DeepEqual(config.Extra, []string{"b,c.txt", "d.txt"}) {
+		t.Errorf("Expected extra [b,c.txt d.txt], got %v", config.Extra)
+	}
+	if len(args) != 4 {
+		t.Errorf("Expected all 4 positional arguments to be returned, got %v", args)
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "  -v --verbose bool  Verbose output\n" +
+		"\n" +
+		"Arguments:\n" +
+		"  source      File to copy\n" +
+		"  dest        Destination directory\n" +
+		"  [files...]  More files to copy\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestFmtTag(t *testing.T) {
+	type Config struct {
+		Ratio float64 `default:"3.14159" fmt:"%.2f" usage:"Ratio"`
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "     --ratio float64  Ratio (default 3.14)\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+
+	output = captureStdout(func() { PrintValues(&Config{Ratio: 2.0 / 3}) })
+	expected = "     --ratio float64  Ratio (current 0.67)\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestRestArgs(t *testing.T) {
+	type Config struct {
+		Verbose bool     `short:"v" usage:"Verbose output"`
+		Action  string   `arg:"action" usage:"Action to take"`
+		Command []string `rest:"true" usage:"Command to run"`
+	}
+
+	var config Config
+	args, _, err := ParseAll(&config, []string{"run", "-v", "--", "echo", "hello,world", "-n"})
+	if err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(config.Command, []string{"echo", "hello,world", "-n"}) {
+		t.Errorf("Expected command [echo hello,world -n], got %v", config.Command)
+	}
+	if !reflect.DeepEqual(args, []string{"run"}) || config.Action != "run" || !config.Verbose {
+		t.Errorf("Expected run as the only positional argument, got %v and %+v", args, config)
+	}
+
+	// -h after -- belongs to the command rather than asking for help
+	config = Config{}
+	if _, _, err := ParseAll(&config, []string{"run", "--", "ls", "-h"}); err != nil {
+		t.Fatalf("ParseAll with -h after -- failed: %v", err)
+	}
+	if !reflect.DeepEqual(config.Command, []string{"ls", "-h"}) {
+		t.Errorf("Expected command [ls -h], got %v", config.Command)
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "  -v --verbose bool  Verbose output\n" +
+		"\n" +
+		"Arguments:\n" +
+		"  action           Action to take\n" +
+		"  -- [command...]  Command to run\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+
+	var twice struct {
+		A []string `rest:"true"`
+		B []string `rest:"true"`
+	}
+	_, _, err = ParseAll(&twice, []string{"--", "x"})
+	if err == nil || !strings.Contains(err.Error(), "only one field may be tagged rest, got A and B") {
+		t.Errorf("Expected error for two rest fields, got %v", err)
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	type Config struct {
+		Token   string   `fromfile:"true"`
+		Keys    []string `fromfile:"true"`
+		Mention string
+	}
+
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	os.WriteFile(tokenFile, []byte("s3cret\n"), 0o600)
+	keyFile := filepath.Join(dir, "key")
+	os.WriteFile(keyFile, []byte("k1"), 0o600)
+
+	var config Config
+	args := []string{"--token=@" + tokenFile, "--keys", "@" + keyFile + ",plain", "--mention=@someone"}
+	if _, _, err := ParseAll(&config, args); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Token != "s3cret" {
+		t.Errorf("Expected token from file, got '%s'", config.Token)
+	}
+	if !reflect.DeepEqual(config.Keys, []string{"k1", "plain"}) {
+		t.Errorf("Expected keys [k1 plain], got %v", config.Keys)
+	}
+	if config.Mention != "@someone" {
+		t.Errorf("Expected literal @someone, got '%s'", config.Mention)
+	}
+
+	_, _, err := ParseAll(&config, []string{"--token=@" + filepath.Join(dir, "missing")})
+	if err == nil || !strings.Contains(err.Error(), "error parsing flag --token") {
+		t.Errorf("Expected error for a missing file, got %v", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	type Logging struct {
+		Level string `default:"info"`
+	}
+	type Config struct {
+		Logging
+		Port    int      `default:"8080"`
+		Tags    []string `default:"a,b"`
+		Verbose bool
+		skipped string
+	}
+
+	config := Config{skipped: "kept"}
+	if _, _, err := ParseAll(&config, []string{"--port", "9090", "--tags", "x", "--level", "debug", "--verbose"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	config.Tags = append(config.Tags, "y")
+
+	if err := Reset(&config); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	expected := Config{Logging: Logging{Level: "info"}, Port: 8080, Tags: []string{"a", "b"}, skipped: "kept"}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, config)
+	}
+}
+
+func TestAppendSlices(t *testing.T) {
+	type Config struct {
+		Paths    []string `append:"true"`
+		Replaced []string
+	}
+
+	os.Setenv("PATHS", "a,b")
+	os.Setenv("REPLACED", "a,b")
+	defer func() {
+		os.Unsetenv("PATHS")
+		os.Unsetenv("REPLACED")
+	}()
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--paths=c", "--replaced=c", "--paths", "d"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(config.Paths, []string{"a", "b", "c", "d"}) {
+		t.Errorf("Expected paths [a b c d], got %v", config.Paths)
+	}
+	if !reflect.DeepEqual(config.Replaced, []string{"c"}) {
+		t.Errorf("Expected replaced [c], got %v", config.Replaced)
+	}
+}
+
+func TestSetMessages(t *testing.T) {
+	type Config struct {
+		Port     int    `usage:"Port" default:"8080" group:"Server"`
+		LogLevel string `usage:"Log level"`
+	}
+
+	SetMessages(map[string]string{
+		"usage_header":   "Verwendung:",
+		"options_header": "Optionen",
+		"group_header":   "%s-Optionen",
+		"default_prefix": "Standard",
+		"current_prefix": "aktuell",
+	})
+	defer SetMessages(nil)
+
+	var err error
+	output := captureStdout(func() { _, _, err = ParseAll(&Config{}, []string{"--help"}) })
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("Expected ErrHelp, got %v", err)
+	}
+	expected := "Verwendung:\n" +
+		"Server-Optionen:\n" +
+		"     --port int          Port (Standard 8080) (aktuell 8080)\n" +
+		"\n" +
+		"Optionen:\n" +
+		"     --log-level string  Log level\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	type Config struct {
+		Timeout time.Duration `default:"5m"`
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, nil); err != nil || config.Timeout != 5*time.Minute {
+		t.Errorf("Expected the default of 5m, got %v, %v", config.Timeout, err)
+	}
+	if _, _, err := ParseAll(&config, []string{"--timeout=30s"}); err != nil || config.Timeout != 30*time.Second {
+		t.Errorf("Expected 30s from the flag, got %v, %v", config.Timeout, err)
+	}
+	_, _, err := ParseAll(&Config{}, []string{"--timeout=30"})
+	if err == nil || !strings.Contains(err.Error(), `invalid duration "30"`) {
+		t.Errorf("Expected error for a duration without a unit, got %v", err)
+	}
+}
+
+func TestDurationSlice(t *testing.T) {
+	type Config struct {
+		Retries []time.Duration `max:"1m"`
+		Timeout time.Duration   `default:"30s"`
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--retries=1s,2s,5s"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(config.Retries, expected) {
+		t.Errorf("Expected Retries %v, got %v", expected, config.Retries)
+	}
+	if config.Timeout != 30*time.Second {
+		t.Errorf("Expected Timeout 30s, got %v", config.Timeout)
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--retries=1s,soon"}, `element 1: invalid duration "soon"`},
+		{[]string{"--retries=1s,2s,2m"}, "element 2: 2m0s is above the maximum of 1m"},
+	}
+	for _, tt := range tests {
+		_, _, err := ParseAll(&Config{}, tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("ParseAll(%v): expected error containing %q, got %v", tt.args, tt.expected, err)
+		}
+	}
+}
+
+func TestRuneAndByteFields(t *testing.T) {
+	type Config struct {
+		Delimiter rune `as:"rune" default:","`
+		Quote     byte `as:"byte"`
+	}
+
+	tests := []struct {
+		args      []string
+		delimiter rune
+		quote     byte
+	}{
+		{[]string{}, ',', 0},
+		{[]string{"--delimiter=;"}, ';', 0},
+		{[]string{"--delimiter=9"}, '9', 0},
+		{[]string{"--delimiter=0x9"}, '\t', 0},
+		{[]string{"--delimiter=é"}, 'é', 0},
+		{[]string{"--quote='"}, ',', '\''},
+		{[]string{"--quote=34"}, ',', '"'},
+	}
+	for _, tt := range tests {
+		var config Config
+		if _, _, err := ParseAll(&config, tt.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tt.args, err)
+		}
+		if config.Delimiter != tt.delimiter || config.Quote != tt.quote {
+			t.Errorf("ParseAll(%v): got %q and %q, want %q and %q", tt.args, config.Delimiter, config.Quote, tt.delimiter, tt.quote)
+		}
+	}
+
+	for _, args := range [][]string{{"--delimiter=ab"}, {"--quote=é"}, {"--quote=300"}} {
+		if _, _, err := ParseAll(&Config{}, args); err == nil {
+			t.Errorf("ParseAll(%v): expected an error", args)
+		}
+	}
+}
+
+func TestJSONValues(t *testing.T) {
+	type Filter struct {
+		Status string `json:"status"`
+		Limit  int    `json:"limit"`
+	}
+	type Config struct {
+		Filter Filter         `as:"json"`
+		Labels map[string]int `as:"json"`
+	}
+
+	var config Config
+	args := []string{`--filter={"status":"active","limit":10}`, `--labels={"a":1,"b":2}`}
+	if _, _, err := ParseAll(&config, args); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Filter != (Filter{Status: "active", Limit: 10}) {
+		t.Errorf("Expected Filter {active 10}, got %+v", config.Filter)
+	}
+	if !reflect.DeepEqual(config.Labels, map[string]int{"a": 1, "b": 2}) {
+		t.Errorf("Expected Labels map[a:1 b:2], got %v", config.Labels)
+	}
+
+	for _, arg := range []string{`--filter={"status":`, `--labels={"a":"x"}`} {
+		_, _, err := ParseAll(&Config{}, []string{arg})
+		if err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+			t.Errorf("ParseAll(%s): expected invalid JSON error, got %v", arg, err)
+		}
+	}
+}
+
+func TestPrintDefaultsRequiredSections(t *testing.T) {
+	type Config struct {
+		Verbose bool   `short:"v" usage:"Verbose output"`
+		Source  string `usage:"Source path" required:"true"`
+		Port    int    `usage:"Port" default:"8080"`
+		Target  string `usage:"Target path" required:"true"`
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "Required flags:\n" +
+		"     --source string  Source path\n" +
+		"     --target string  Target path\n" +
+		"\n" +
+		"Optional flags:\n" +
+		"  -v --verbose bool   Verbose output\n" +
+		"     --port int       Port (default 8080)\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestShortAliases(t *testing.T) {
+	type Config struct {
+		Verbose bool   `short:"v,d" usage:"Verbose output"`
+		Output  string `short:"o" usage:"Output file"`
+	}
+
+	for _, args := range [][]string{{"-v"}, {"-d"}, {"-vd"}, {"--verbose"}} {
+		var config Config
+		if _, _, err := ParseAll(&config, args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", args, err)
+		}
+		if !config.Verbose {
+			t.Errorf("ParseAll(%v): expected Verbose to be set", args)
+		}
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "  -v,-d --verbose bool   Verbose output\n" +
+		"  -o    --output string  Output file\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestSetSortFlags(t *testing.T) {
+	type Config struct {
+		Verbose bool   `short:"v" usage:"Verbose output"`
+		Address string `short:"z" usage:"Listen address"`
+		Mode    string `usage:"Run mode"`
+		Timeout int    `usage:"Timeout in seconds"`
+	}
+
+	SetSortFlags(true)
+	defer SetSortFlags(false)
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "  -z --address string  Listen address\n" +
+		"     --mode string     Run mode\n" +
+		"     --timeout int     Timeout in seconds\n" +
+		"  -v --verbose bool    Verbose output\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestSetAutoShort(t *testing.T) {
+	type Config struct {
+		Port    int    `usage:"Port to listen on"`
+		Path    string `usage:"Path to serve"`
+		Verbose bool   `usage:"Verbose output"`
+		Pa      string `usage:"Out of letters"`
+	}
+
+	SetAutoShort(true)
+	defer SetAutoShort(false)
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "  -p --port int      Port to listen on\n" +
+		"  -a --path string   Path to serve\n" +
+		"  -v --verbose bool  Verbose output\n" +
+		"     --pa string     Out of letters\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"-p", "8080", "-a", "/srv", "-v"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Port != 8080 || config.Path != "/srv" || !config.Verbose {
+		t.Errorf("Expected port 8080, path /srv and verbose, got %+v", config)
+	}
+}
+
+func TestNestedFlags(t *testing.T) {
+	type Log struct {
+		Level   string
+		Verbose bool
+	}
+	type Config struct {
+		Log  Log
+		Port int
+	}
+
+	var config Config
+	result, err := ParseResult(&config, []string{"--log.level=debug", "--log/verbose", "--port=80"})
+	if err != nil {
+		t.Fatalf("ParseResult failed: %v", err)
+	}
+	if config.Log != (Log{Level: "debug", Verbose: true}) || config.Port != 80 {
+		t.Errorf("Expected Log {debug true} and Port 80, got %+v", config)
+	}
+	if !result.Sources.IsSet("Log.Level") {
+		t.Errorf("Expected Log.Level to be set by a flag, got %v", result.Sources)
+	}
+
+	// Nested bools don't take the next token, and nested slices collect
+	// repeated flags like other slices
+	type Server struct {
+		Tags []string `unique:"true"`
+	}
+	var nested struct {
+		Log    Log
+		Server Server
+	}
+	args, _, err := ParseAll(&nested, []string{"--log/verbose", "cmd", "--server.tags=a,b", "--server/tags=b,c"})
+	if err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !nested.Log.Verbose || !reflect.DeepEqual(args, []string{"cmd"}) {
+		t.Errorf("Expected verbose and positional [cmd], got %+v and %v", nested.Log, args)
+	}
+	if !reflect.DeepEqual(nested.Server.Tags, []string{"a", "b", "c"}) {
+		t.Errorf("Expected tags [a b c], got %v", nested.Server.Tags)
+	}
+
+	// Nested fields get their default tags and are listed by their dotted name
+	type Output struct {
+		Format string `default:"text" usage:"Output format"`
+	}
+	type Documented struct {
+		Output Output
+		Port   int `short:"p"`
+	}
+	var documented Documented
+	result, err = ParseResult(&documented, nil)
+	if err != nil {
+		t.Fatalf("ParseResult failed: %v", err)
+	}
+	if documented.Output.Format != "text" || result.Sources["Output.Format"] != SourceDefault {
+		t.Errorf("Expected the nested default text, got %q from %v", documented.Output.Format, result.Sources)
+	}
+	expectedInfos := []FlagInfo{
+		{Name: "output.format", Type: "string", Default: "text", Usage: "Output format"},
+		{Name: "port", Short: "p", Type: "int"},
+	}
+	if infos := Describe(&documented); !reflect.DeepEqual(infos, expectedInfos) {
+		t.Errorf("Expected %+v, got %+v", expectedInfos, infos)
+	}
+	var help bytes.Buffer
+	FprintDefaults(&help, &Documented{})
+	expectedHelp := "     --output.format string  Output format (default text)\n" +
+		"  -p --port int              \n"
+	if help.String() != expectedHelp {
+		t.Errorf("Expected help:\n%q\ngot:\n%q", expectedHelp, help.String())
+	}
+
+	// An environment variable named like a nested struct is ignored
+	type UserCfg struct {
+		Name string
+	}
+	var user struct {
+		User UserCfg
+	}
+	if _, _, err := NewFlagSet([]string{"--user.name=alice"}, io.Discard, map[string]string{"USER": "root"}).ParseAll(&user); err != nil {
+		t.Fatalf("ParseAll with USER set failed: %v", err)
+	}
+	if user.User.Name != "alice" {
+		t.Errorf("Expected user name alice, got %q", user.User.Name)
+	}
+
+	SetStrict(true)
+	defer SetStrict(false)
+
+	for _, arg := range []string{"--log.color=auto", "--port.level=x"} {
+		_, _, err := ParseAll(&Config{}, []string{arg})
+		expected := "unknown flag " + strings.SplitN(arg, "=", 2)[0]
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("ParseAll(%s): expected error containing %q, got %v", arg, expected, err)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	type Config struct {
+		AppName string `default:"app"`
+		LogDir  string `default:"/var/log/${app-name}" interpolate:"true"`
+		LogFile string `default:"${log-dir}/${app-name}.log" interpolate:"true"`
+		Literal string `default:"${app-name}"`
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--app-name=web"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.LogFile != "/var/log/web/web.log" {
+		t.Errorf("Expected LogFile '/var/log/web/web.log', got '%s'", config.LogFile)
+	}
+	if config.Literal != "${app-name}" {
+		t.Errorf("Expected Literal to stay '${app-name}', got '%s'", config.Literal)
+	}
+
+	type Broken struct {
+		Missing string `default:"${nope}" interpolate:"true"`
+	}
+	_, _, err := ParseAll(&Broken{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "flag --missing refers to unknown flag --nope") {
+		t.Errorf("Expected unknown reference error, got %v", err)
+	}
+
+	type Cycle struct {
+		A string `default:"${b}" interpolate:"true"`
+		B string `default:"x${a}" interpolate:"true"`
+	}
+	_, _, err = ParseAll(&Cycle{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "cyclic reference --a -> --b -> --a") {
+		t.Errorf("Expected cyclic reference error, got %v", err)
+	}
+}
+
+func TestTruthyFalsy(t *testing.T) {
+	type Config struct {
+		Access bool `truthy:"enabled,allow" falsy:"disabled,deny" default:"true"`
+	}
+
+	tests := []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"--access=disabled"}, false},
+		{[]string{"--access=deny"}, false},
+		{[]string{"--access=false", "--access=enabled"}, true},
+		{[]string{"--access=false"}, false},
+		{[]string{"--access=0", "--access=allow"}, true},
+		{[]string{"--access=1"}, true},
+	}
+	for _, tt := range tests {
+		var config Config
+		if _, _, err := ParseAll(&config, tt.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tt.args, err)
+		}
+		if config.Access != tt.expected {
+			t.Errorf("ParseAll(%v): expected %v, got %v", tt.args, tt.expected, config.Access)
+		}
+	}
+
+	if _, _, err := ParseAll(&Config{}, []string{"--access=Enabled"}); err == nil {
+		t.Errorf("Expected an error for a value outside the token sets")
+	}
+}
+
+func TestFprintDefaults(t *testing.T) {
+	type Config struct {
+		Port int    `short:"p" usage:"Port to listen on" default:"8080"`
+		Host string `usage:"Host address"`
+	}
+
+	var buf bytes.Buffer
+	FprintDefaults(&buf, &Config{})
+	expected := "  -p --port int     Port to listen on (default 8080)\n" +
+		"     --host string  Host address\n"
+	if buf.String() != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, buf.String())
+	}
+	if output := captureStdout(func() { PrintDefaults(&Config{}) }); output != expected {
+		t.Errorf("Expected PrintDefaults to match FprintDefaults, got:\n%s", output)
+	}
+}
+
+func TestPrintValues(t *testing.T) {
+	type Config struct {
+		Port     int    `usage:"Port" default:"8080" env:"VALUES_PORT"`
+		Host     string `usage:"Host" default:"localhost"`
+		Password string `usage:"Password" default:"hunter2" secret:"true"`
+		Verbose  bool   `usage:"Verbose output"`
+	}
+
+	os.Setenv("VALUES_PORT", "3000")
+	defer os.Unsetenv("VALUES_PORT")
+
+	var config Config
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if err := ParseEnv(&config); err != nil {
+		t.Fatalf("ParseEnv failed: %v", err)
+	}
+
+	output := captureStdout(func() { PrintValues(&config) })
+	expected := "     --port int         Port (current 3000)\n" +
+		"     --host string      Host (current localhost)\n" +
+		"     --password string  Password\n" +
+		"     --verbose bool     Verbose output\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+}
+
+func TestUniqueSlice(t *testing.T) {
+	type Config struct {
+		Tags   []string `unique:"true"`
+		Labels []string
+		Ports  []int `unique:"true" append:"true" default:"80"`
+	}
+
+	var config Config
+	args := []string{"--tags", "a,b", "--tags", "b,c", "--labels", "a,b", "--labels", "b,c", "--ports=443,80,443"}
+	if _, _, err := ParseAll(&config, args); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(config.Tags, []string{"a", "b", "c"}) {
+		t.Errorf("Expected unique Tags [a b c], got %v", config.Tags)
+	}
+	if !reflect.DeepEqual(config.Labels, []string{"a", "b", "b", "c"}) {
+		t.Errorf("Expected Labels [a b b c], got %v", config.Labels)
+	}
+	if !reflect.DeepEqual(config.Ports, []int{80, 443}) {
+		t.Errorf("Expected unique Ports [80 443], got %v", config.Ports)
+	}
+}
+
+func TestUnsupportedTypeError(t *testing.T) {
+	type Config struct {
+		Events  chan int
+		Streams []chan int `env:"UNSUPPORTED_STREAMS"`
+	}
+
+	_, _, err := ParseAll(&Config{}, []string{"--events=x"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported flag type chan int for field Events (--events)") {
+		t.Errorf("Expected unsupported type error naming the field, got %v", err)
+	}
+
+	os.Setenv("UNSUPPORTED_STREAMS", "a,b")
+	defer os.Unsetenv("UNSUPPORTED_STREAMS")
+	_, _, err = ParseAll(&Config{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "unsupported flag type []chan int for field Streams (--streams)") {
+		t.Errorf("Expected unsupported type error naming the field, got %v", err)
+	}
+
+	var events chan int
+	err = SetField(reflect.ValueOf(&events).Elem(), "x", true)
+	var typeErr *UnsupportedTypeError
+	if !errors.As(err, &typeErr) || typeErr.Type != reflect.TypeOf(events) {
+		t.Errorf("Expected an UnsupportedTypeError for chan int, got %v", err)
+	}
+}
+
+func TestParseFlagsFile(t *testing.T) {
+	type Config struct {
+		Port    int      `default:"8080"`
+		Host    string   `default:"localhost"`
+		Verbose bool     `short:"v"`
+		Tags    []string `env:"FLAGS_FILE_TAGS"`
+	}
+
+	path := filepath.Join(t.TempDir(), "flags")
+	os.WriteFile(path, []byte("# Overrides\nport=9090\nhost = example.com\n\nv\ntags=a,b\n"), 0o600)
+
+	os.Setenv("FLAGS_FILE_TAGS", "env")
+	defer os.Unsetenv("FLAGS_FILE_TAGS")
+
+	var config Config
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if err := ParseEnv(&config); err != nil {
+		t.Fatalf("ParseEnv failed: %v", err)
+	}
+	if err := ParseFlagsFile(&config, path); err != nil {
+		t.Fatalf("ParseFlagsFile failed: %v", err)
+	}
+	_, flags := ParseArgsOrdered([]string{"--port=7070"}, NewArgSpec(&config))
+	if err := SetFlagsOrdered(&config, flags); err != nil {
+		t.Fatalf("SetFlagsOrdered failed: %v", err)
+	}
+
+	expected := Config{Port: 7070, Host: "example.com", Verbose: true, Tags: []string{"a", "b"}}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, config)
+	}
+
+	os.WriteFile(path, []byte("host=other.example.com\n\nport=x\n"), 0o600)
+	if err := ParseFlagsFile(&config, path); err == nil || !strings.Contains(err.Error(), "error parsing flags file "+path+", line 3:") {
+		t.Errorf("Expected an error for an invalid value on line 3, got %v", err)
+	}
+	if config.Host != "example.com" {
+		t.Errorf("Expected host to be unchanged, got %s", config.Host)
+	}
+
+	SetStrict(true)
+	defer SetStrict(false)
+	os.WriteFile(path, []byte("# Unknown\nx=1\n"), 0o600)
+	if err := ParseFlagsFile(&config, path); err == nil || !strings.HasSuffix(err.Error(), "line 2: unknown flag -x") {
+		t.Errorf("Expected unknown flag -x on line 2, got %v", err)
+	}
+	os.WriteFile(path, []byte("colour\n"), 0o600)
+	if err := ParseFlagsFile(&config, path); err == nil || !strings.HasSuffix(err.Error(), "line 1: unknown flag --colour") {
+		t.Errorf("Expected unknown flag --colour on line 1, got %v", err)
+	}
+}
+
+func TestSetLenientParsing(t *testing.T) {
+	type Config struct {
+		Port  int
+		Count uint
+		Ratio float64
+	}
+
+	if _, _, err := ParseAll(&Config{}, []string{"--port=8080.0"}); err == nil {
+		t.Errorf("Expected 8080.0 to be an error without lenient parsing")
+	}
+
+	SetLenientParsing(true)
+	defer SetLenientParsing(false)
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--port=8080.0", "--count= 42 ", "--ratio=1,234.5"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config != (Config{Port: 8080, Count: 42, Ratio: 1234.5}) {
+		t.Errorf("Expected {8080 42 1234.5}, got %+v", config)
+	}
+
+	for _, arg := range []string{"--port=8080.5", "--port=thirty", "--count=-1", "--ratio=1.2.3", "--ratio=12,34", "--ratio=1,2,3", "--ratio=1234,567.8"} {
+		if _, _, err := ParseAll(&Config{}, []string{arg}); err == nil {
+			t.Errorf("ParseAll(%s): expected an error in lenient mode", arg)
+		}
+	}
+}
+
+// hostList implements flag.Value from the standard library.
+type hostList []string
+
+func (h *hostList) Set(value string) error {
+	if value == "" {
+		return errors.New("empty host")
+	}
+	*h = append(*h, strings.Split(value, "+")...)
+	return nil
+}
+
+func (h hostList) String() string {
+	return strings.Join(h, "+")
+}
+
+func TestValueSetter(t *testing.T) {
+	type Config struct {
+		Hosts hostList `usage:"Hosts" default:"a+b"`
+	}
+
+	var config Config
+	if _, _, err := ParseAll(&config, []string{"--hosts=c+d"}); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(config.Hosts, hostList{"a", "b", "c", "d"}) {
+		t.Errorf("Expected Set to be called for the default and the flag, got %v", config.Hosts)
+	}
+
+	output := captureStdout(func() { PrintDefaults(&Config{}) })
+	expected := "     --hosts hostList  Hosts (default a+b)\n"
+	if output != expected {
+		t.Errorf("Expected output does not match actual output.\nExpected:\n%s\nActual:\n%s", expected, output)
+	}
+
+	if _, _, err := ParseAll(&Config{}, []string{"--hosts="}); err == nil || !strings.Contains(err.Error(), "empty host") {
+		t.Errorf("Expected the error from Set, got %v", err)
+	}
+}
+
+func TestClusterWithValueFlag(t *testing.T) {
+	type Config struct {
+		Extract bool   `short:"x"`
+		Verbose bool   `short:"v"`
+		File    string `short:"f"`
+	}
+
+	var config Config
+	args, _, err := ParseAll(&config, []string{"-xvf", "output.tar", "src"})
+	if err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config != (Config{Extract: true, Verbose: true, File: "output.tar"}) {
+		t.Errorf("Expected {true true output.tar}, got %+v", config)
+	}
+	if !reflect.DeepEqual(args, []string{"src"}) {
+		t.Errorf("Expected positionals [src], got %v", args)
+	}
+}
+
+func TestWalkFields(t *testing.T) {
+	type Log struct {
+		Level string `default:"info"`
+	}
+	type Config struct {
+		ServerFlags
+		PortNumber int `default:"8080"`
+		Log        Log
+		Internal   string `flag:"-"`
+	}
+
+	var paths []string
+	defaults := map[string]string{}
+	err := WalkFields(&Config{}, func(field reflect.Value, tag reflect.StructTag, path string) error {
+		paths = append(paths, path)
+		if def := tag.Get("default"); def != "" {
+			defaults[path] = def
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkFields failed: %v", err)
+	}
+	expected := []string{"port", "port-number", "log.level"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected paths %v, got %v", expected, paths)
+	}
+	if defaults["log.level"] != "info" {
+		t.Errorf("Expected the tag of log.level, got %v", defaults)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = WalkFields(&Config{}, func(field reflect.Value, tag reflect.StructTag, path string) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("Expected the walk to stop at the first error, got %v after %d calls", err, count)
+	}
+}
+
+func TestParseEnvEmptyBool(t *testing.T) {
+	type Config struct {
+		Verbose bool `default:"true"`
+		Debug   *bool
+		Name    string `default:"app"`
+	}
+
+	tests := []struct {
+		env     map[string]string
+		verbose bool
+		name    string
+	}{
+		{map[string]string{"VERBOSE": "", "NAME": ""}, true, ""},
+		{map[string]string{"VERBOSE": "true"}, true, "app"},
+		{map[string]string{"VERBOSE": "false"}, false, "app"},
+	}
+	for _, tt := range tests {
+		var config Config
+		if err := SetDefaults(&config); err != nil {
+			t.Fatalf("SetDefaults failed: %v", err)
+		}
+		if err := ParseEnvFrom(&config, tt.env); err != nil {
+			t.Fatalf("ParseEnvFrom(%v) failed: %v", tt.env, err)
+		}
+		if config.Verbose != tt.verbose || config.Name != tt.name {
+			t.Errorf("ParseEnvFrom(%v): got verbose=%v name=%q, want %v %q", tt.env, config.Verbose, config.Name, tt.verbose, tt.name)
+		}
+	}
+
+	var config Config
+	if err := ParseEnvFrom(&config, map[string]string{"DEBUG": ""}); err != nil || config.Debug != nil {
+		t.Errorf("Expected an empty DEBUG to leave the *bool nil, got %v, %v", config.Debug, err)
+	}
+}
+
+func TestSetFlagsSubset(t *testing.T) {
+	type Config struct {
+		PortNumber int    `short:"p"`
+		HostName   string `default:"localhost"`
+		LogLevel   string
+	}
+
+	config := Config{HostName: "prior"}
+	flags := map[string]string{"p": "9090", "host-name": "example.com", "log-level": "debug", "unknown": "x"}
+	if err := SetFlagsSubset(&config, flags, []string{"PortNumber", "log-level"}); err != nil {
+		t.Fatalf("SetFlagsSubset failed: %v", err)
+	}
+	expected := Config{PortNumber: 9090, HostName: "prior", LogLevel: "debug"}
+	if config != expected {
+		t.Errorf("Expected %+v, got %+v", expected, config)
+	}
+}
@@ -0,0 +1,99 @@
+package flag
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// FlagSet holds the output, environment and arguments that the package
+// functions take from the process, so a config can be parsed without
+// touching os.Stdout, the real environment or os.Args, as in servers and
+// tests. Its environment is also used for NO_COLOR and COLUMNS in the help.
+//
+// The other settings are still shared by all FlagSets and the package
+// functions: strict mode, precedence, registered parsers, hooks, messages,
+// color, help width and the rest of the Set functions. Apart from
+// RegisterParser, changing them while another goroutine parses is a data
+// race, so configure them once at startup.
+type FlagSet struct {
+	// Output receives the help output. When nil, the writer set with
+	// SetOutput is used, which defaults to os.Stdout.
+	Output io.Writer
+
+	// LookupEnv looks up environment variables. When nil, os.LookupEnv is
+	// used.
+	LookupEnv func(key string) (string, bool)
+
+	// Args holds the command-line arguments without the program name. When
+	// nil, os.Args[1:] is used.
+	Args []string
+}
+
+// NewFlagSet returns a FlagSet for args that writes help output to out and
+// looks up environment variables in env.
+func NewFlagSet(args []string, out io.Writer, env map[string]string) *FlagSet {
+	return &FlagSet{
+		Output: out,
+		LookupEnv: func(key string) (string, bool) {
+			value, ok := env[key]
+			return value, ok
+		},
+		Args: args,
+	}
+}
+
+// ParseAll is like the package function ParseAll, with the output,
+// environment and arguments of the FlagSet.
+func (fs *FlagSet) ParseAll(config interface{}) ([]string, map[string]string, error) {
+	result, err := fs.ParseResult(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.RemainingArgs, result.Flags.Map(), nil
+}
+
+// ParseResult is like the package function ParseResult, with the output,
+// environment and arguments of the FlagSet.
+func (fs *FlagSet) ParseResult(config interface{}) (*Result, error) {
+	return parseAll(context.Background(), config, fs.args(), fs.lookupEnv(), fs.output())
+}
+
+// SetDefaults is like the package function SetDefaults, with defaultEnv
+// tags looked up in the environment of the FlagSet.
+func (fs *FlagSet) SetDefaults(config interface{}) error {
+	return setDefaults(config, fs.lookupEnv(), nil)
+}
+
+// ParseEnv is like the package function ParseEnv, with the environment of
+// the FlagSet.
+func (fs *FlagSet) ParseEnv(config interface{}) error {
+	return parseEnv(config, fs.lookupEnv(), nil)
+}
+
+// PrintDefaults is like the package function PrintDefaults, writing to the
+// output of the FlagSet.
+func (fs *FlagSet) PrintDefaults(config interface{}) {
+	printDefaults(fs.output(), config, false, fs.lookupEnv())
+}
+
+func (fs *FlagSet) output() io.Writer {
+	if fs.Output != nil {
+		return fs.Output
+	}
+	return helpOutput()
+}
+
+func (fs *FlagSet) lookupEnv() func(string) (string, bool) {
+	if fs.LookupEnv != nil {
+		return fs.LookupEnv
+	}
+	return os.LookupEnv
+}
+
+func (fs *FlagSet) args() []string {
+	if fs.Args != nil {
+		return fs.Args
+	}
+	return os.Args[1:]
+}
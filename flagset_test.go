@@ -0,0 +1,68 @@
+package flag_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/bartdeboer/flag"
+)
+
+func TestFlagSet(t *testing.T) {
+	type Config struct {
+		Host    string `default:"localhost" usage:"Host to bind"`
+		Port    int    `usage:"Port to listen on"`
+		Verbose bool   `short:"v"`
+		Home    string `defaultEnv:"APP_HOME"`
+	}
+
+	t.Setenv("PORT", "1111")
+	var out bytes.Buffer
+	env := map[string]string{"PORT": "8080", "APP_HOME": "/srv"}
+	fs := NewFlagSet([]string{"-v", "run"}, &out, env)
+
+	var config Config
+	args, _, err := fs.ParseAll(&config)
+	if err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Host != "localhost" || config.Port != 8080 || !config.Verbose || config.Home != "/srv" {
+		t.Errorf("Expected the FlagSet environment and arguments to be used, got %+v", config)
+	}
+	if len(args) != 1 || args[0] != "run" {
+		t.Errorf("Expected positional arguments [run], got %v", args)
+	}
+
+	fs.Args = []string{"--help"}
+	if _, _, err := fs.ParseAll(&Config{}); !errors.Is(err, ErrHelp) {
+		t.Fatalf("Expected ErrHelp, got %v", err)
+	}
+	if !strings.Contains(out.String(), "--port int") {
+		t.Errorf("Expected help in the FlagSet output, got %q", out.String())
+	}
+
+	out.Reset()
+	fs.PrintDefaults(&Config{})
+	if !strings.Contains(out.String(), "Host to bind (default localhost)") {
+		t.Errorf("Expected help in the FlagSet output, got %q", out.String())
+	}
+
+	// COLUMNS comes from the FlagSet environment as well
+	defer ResetHelpWidth()()
+	t.Setenv("COLUMNS", "200")
+	env["COLUMNS"] = "40"
+	out.Reset()
+	fs.PrintDefaults(&Config{})
+	if !strings.Contains(out.String(), "Host to bind\n") {
+		t.Errorf("Expected the usage wrapped at 40 columns, got %q", out.String())
+	}
+
+	config = Config{}
+	if err := fs.SetDefaults(&config); err != nil || config.Home != "/srv" {
+		t.Errorf("Expected SetDefaults to read APP_HOME from the FlagSet, got %q, %v", config.Home, err)
+	}
+	if err := fs.ParseEnv(&config); err != nil || config.Port != 8080 {
+		t.Errorf("Expected ParseEnv to read PORT from the FlagSet, got %d, %v", config.Port, err)
+	}
+}
@@ -2,4 +2,9 @@ module github.com/bartdeboer/flag
 
 go 1.22.3
 
-require github.com/bartdeboer/words v0.0.2
+require (
+	github.com/bartdeboer/words v0.0.2
+	golang.org/x/term v0.28.0
+)
+
+require golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,40 @@
+package flag
+
+// Hooks added with RegisterPreParse and RegisterPostParse.
+var (
+	preParseHooks  []func(args []string)
+	postParseHooks []func(config interface{}, err error)
+)
+
+// RegisterPreParse registers fn to be called with the arguments at the start
+// of every ParseAll, before anything is parsed. Hooks run in registration
+// order.
+func RegisterPreParse(fn func(args []string)) {
+	preParseHooks = append(preParseHooks, fn)
+}
+
+// RegisterPostParse registers fn to be called at the end of every ParseAll
+// with the config and the error ParseAll returns, which is nil on success.
+// Hooks run in registration order.
+func RegisterPostParse(fn func(config interface{}, err error)) {
+	postParseHooks = append(postParseHooks, fn)
+}
+
+func runPreParse(args []string) {
+	for _, hook := range preParseHooks {
+		runHook(func() { hook(args) })
+	}
+}
+
+func runPostParse(config interface{}, err error) {
+	for _, hook := range postParseHooks {
+		runHook(func() { hook(config, err) })
+	}
+}
+
+// runHook calls fn, recovering from a panic so that one failing hook doesn't
+// stop the other hooks or the parse.
+func runHook(fn func()) {
+	defer func() { recover() }()
+	fn()
+}
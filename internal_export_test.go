@@ -0,0 +1,23 @@
+package flag
+
+// SetExit replaces the function Fatal exits with and returns a function
+// restoring it.
+func SetExit(exit func(code int)) (restore func()) {
+	original := osExit
+	osExit = exit
+	return func() { osExit = original }
+}
+
+// ResetHooks removes the hooks added with RegisterPreParse and
+// RegisterPostParse.
+func ResetHooks() {
+	preParseHooks, postParseHooks = nil, nil
+}
+
+// ResetHelpWidth makes the help width follow $COLUMNS again, as before any
+// SetHelpWidth, and returns a function restoring the previous width.
+func ResetHelpWidth() (restore func()) {
+	width, set := helpWidth, helpWidthSet
+	helpWidth, helpWidthSet = 0, false
+	return func() { helpWidth, helpWidthSet = width, set }
+}
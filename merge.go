@@ -0,0 +1,72 @@
+package flag
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// SlicePolicy controls how Merge combines slice fields.
+type SlicePolicy int
+
+const (
+	ReplaceSlices SlicePolicy = iota // A non-empty slice in src replaces the one in dst
+	AppendSlices                     // A non-empty slice in src is appended to the one in dst
+)
+
+// Merge copies the non-zero fields of src into dst, which must be a pointer
+// to a struct of the same type as src. This allows composing a config from
+// several parsed configs, such as a base config and a plugin config.
+//
+// Nested and embedded structs are merged field by field. Structs parsed from
+// a single value, such as time.Time, url.URL and net.IPNet, are copied whole.
+// Slices are replaced or appended to according to policy, and maps merge
+// their entries, with the entries of src winning.
+func Merge(dst, src interface{}, policy SlicePolicy) error {
+	d, err := configStruct(dst)
+	if err != nil {
+		return err
+	}
+	s := reflect.Indirect(reflect.ValueOf(src))
+	if !s.IsValid() || s.Type() != d.Type() {
+		return fmt.Errorf("cannot merge %T into %T", src, dst)
+	}
+	mergeStruct(d, s, policy)
+	return nil
+}
+
+// mergeStruct merges the exported fields of src into dst.
+func mergeStruct(dst, src reflect.Value, policy SlicePolicy) {
+	for i := 0; i < dst.NumField(); i++ {
+		d, s := dst.Field(i), src.Field(i)
+		if !d.CanSet() || s.IsZero() {
+			continue
+		}
+		switch {
+		case d.Kind() == reflect.Struct && !isValueStruct(d.Type()):
+			mergeStruct(d, s, policy)
+		case d.Kind() == reflect.Slice && policy == AppendSlices:
+			d.Set(reflect.AppendSlice(copyValue(d), s))
+		case d.Kind() == reflect.Map && !d.IsNil():
+			m := copyValue(d)
+			for iter := s.MapRange(); iter.Next(); {
+				m.SetMapIndex(iter.Key(), iter.Value())
+			}
+			d.Set(m)
+		default:
+			d.Set(copyValue(s))
+		}
+	}
+}
+
+// isValueStruct reports whether values of the struct type t are parsed from
+// a single flag value rather than holding fields of their own.
+func isValueStruct(t reflect.Type) bool {
+	switch t {
+	case timeType, ipNetType, urlType:
+		return true
+	}
+	if _, ok := lookupParser(t); ok {
+		return true
+	}
+	return reflect.PtrTo(t).Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(valueSetterType)
+}
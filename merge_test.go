@@ -0,0 +1,70 @@
+package flag_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/bartdeboer/flag"
+)
+
+type mergeConfig struct {
+	Name    string
+	Port    int
+	Plugins []string
+	Labels  map[string]string
+	Server  struct {
+		Host string
+		TLS  bool
+	}
+}
+
+func TestMerge(t *testing.T) {
+	newBase := func() mergeConfig {
+		var base mergeConfig
+		base.Name = "base"
+		base.Port = 8080
+		base.Plugins = []string{"auth"}
+		base.Labels = map[string]string{"env": "dev", "team": "core"}
+		base.Server.Host = "localhost"
+		return base
+	}
+	var plugin mergeConfig
+	plugin.Port = 9090
+	plugin.Plugins = []string{"metrics"}
+	plugin.Labels = map[string]string{"env": "prod"}
+	plugin.Server.TLS = true
+
+	tests := []struct {
+		policy  SlicePolicy
+		plugins []string
+	}{
+		{ReplaceSlices, []string{"metrics"}},
+		{AppendSlices, []string{"auth", "metrics"}},
+	}
+	for _, tt := range tests {
+		config := newBase()
+		if err := Merge(&config, plugin, tt.policy); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if config.Name != "base" || config.Port != 9090 {
+			t.Errorf("Expected Name base and Port 9090, got %q and %d", config.Name, config.Port)
+		}
+		if !reflect.DeepEqual(config.Plugins, tt.plugins) {
+			t.Errorf("Policy %d: expected Plugins %v, got %v", tt.policy, tt.plugins, config.Plugins)
+		}
+		expectedLabels := map[string]string{"env": "prod", "team": "core"}
+		if !reflect.DeepEqual(config.Labels, expectedLabels) {
+			t.Errorf("Expected Labels %v, got %v", expectedLabels, config.Labels)
+		}
+		if config.Server.Host != "localhost" || !config.Server.TLS {
+			t.Errorf("Expected Server {localhost true}, got %+v", config.Server)
+		}
+	}
+
+	config := newBase()
+	err := Merge(&config, struct{ Name string }{"other"}, ReplaceSlices)
+	if err == nil || !strings.Contains(err.Error(), "cannot merge") {
+		t.Errorf("Expected a type mismatch error, got %v", err)
+	}
+}
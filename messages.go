@@ -0,0 +1,37 @@
+package flag
+
+// defaultMessages holds the English labels of the help output, keyed by
+// message ID.
+var defaultMessages = map[string]string{
+	"usage_header":       "Usage:",
+	"options_header":     "Options",
+	"group_header":       "%s options", // %s is the group name
+	"required_header":    "Required flags",
+	"optional_header":    "Optional flags",
+	"arguments_header":   "Arguments",
+	"environment_header": "Environment",
+	"commands_header":    "Commands",
+	"default_prefix":     "default",
+	"current_prefix":     "current",
+}
+
+// messages holds the labels set with SetMessages.
+var messages map[string]string
+
+// SetMessages replaces the labels of the help output, such as the "Usage:"
+// header and the "default" in "(default 8080)", for translation. The keys
+// are the message IDs usage_header, options_header, group_header,
+// required_header, optional_header, arguments_header, environment_header,
+// commands_header, default_prefix and current_prefix.
+// Missing IDs fall back to English, and nil restores all English labels.
+func SetMessages(m map[string]string) {
+	messages = m
+}
+
+// message returns the label for id.
+func message(id string) string {
+	if msg, ok := messages[id]; ok {
+		return msg
+	}
+	return defaultMessages[id]
+}
@@ -0,0 +1,123 @@
+package flag
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// output is the writer for help output. When nil, os.Stdout is used.
+var output io.Writer
+
+// SetOutput sets the writer PrintDefaults, the --help handling of ParseAll and
+// Fatal write to. A nil writer restores the defaults of os.Stdout for help and
+// os.Stderr for errors.
+func SetOutput(w io.Writer) {
+	output = w
+}
+
+// helpOutput returns the writer for help output.
+func helpOutput() io.Writer {
+	if output != nil {
+		return output
+	}
+	return os.Stdout
+}
+
+// errorOutput returns the writer for error output, which is os.Stderr unless
+// SetOutput was called.
+func errorOutput() io.Writer {
+	if output != nil {
+		return output
+	}
+	return os.Stderr
+}
+
+// osExit exits the program. Tests replace it to check the exit code.
+var osExit = os.Exit
+
+// Fatal handles an error returned by ParseAll the way command-line programs
+// conventionally do. For ErrHelp and ErrConfigDump, after the output has been
+// printed, it exits with code 0. For other errors it prints the error and the
+// usage of config to os.Stderr, or the writer set with SetOutput, and exits
+// with code 2. It does nothing when err is nil.
+func Fatal(config interface{}, err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, ErrHelp) || errors.Is(err, ErrConfigDump) {
+		osExit(0)
+		return
+	}
+	w := errorOutput()
+	fmt.Fprintf(w, "Error: %v\n", err)
+	fmt.Fprintln(w, message("usage_header"))
+	printDefaults(w, config, false, os.LookupEnv)
+	osExit(2)
+}
+
+// colorMode is the color setting of the help output.
+type colorMode int
+
+const (
+	colorAuto colorMode = iota // Color on terminals, unless NO_COLOR is set
+	colorOn
+	colorOff
+)
+
+var color = colorAuto
+
+// SetColor forces colored help output on or off. By default, help output
+// is colored when written to a terminal and the NO_COLOR environment
+// variable is not set.
+func SetColor(enabled bool) {
+	if enabled {
+		color = colorOn
+	} else {
+		color = colorOff
+	}
+}
+
+// ResetColor undoes SetColor, so help output is colored again depending on
+// the terminal and NO_COLOR.
+func ResetColor() {
+	color = colorAuto
+}
+
+// ANSI escape codes used in the help output.
+const (
+	colorName  = "\x1b[36m" // Flag names in cyan
+	colorDim   = "\x1b[2m"  // Defaults dimmed
+	colorReset = "\x1b[0m"
+)
+
+// useColor reports whether help output written to w should be colored, with
+// NO_COLOR looked up with lookupEnv.
+func useColor(w io.Writer, lookupEnv func(string) (string, bool)) bool {
+	switch color {
+	case colorOn:
+		return true
+	case colorOff:
+		return false
+	}
+	if _, ok := lookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return isTerminal(w)
+}
+
+// isTerminal reports whether w is a character device such as a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps s in the ANSI color code.
+func paint(code, s string) string {
+	return code + s + colorReset
+}
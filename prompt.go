@@ -0,0 +1,105 @@
+package flag
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Prompt input and output. When promptIn is nil, prompts read from os.Stdin
+// if it is a terminal and write to os.Stderr.
+var (
+	promptIn  io.Reader
+	promptOut io.Writer
+)
+
+// SetPromptIO sets the reader prompts read answers from and the writer they
+// write questions to. A reader set here is always taken as interactive, so
+// prompts can be answered from tests or scripts. Passing nil for both
+// restores reading from a terminal on os.Stdin.
+func SetPromptIO(r io.Reader, w io.Writer) {
+	promptIn, promptOut = r, w
+}
+
+// fillRequired prompts for fields tagged prompt that no other source set and
+// that are still zero, when input is interactive, so an explicit --retries=0
+// is kept. It then returns an error naming every field tagged required:"true"
+// that is still zero and wasn't given explicitly, as in --count=0.
+func fillRequired(config interface{}, sources Sources) error {
+	v, err := configStruct(config)
+	if err != nil {
+		return err
+	}
+
+	in, out, echo := promptIn, promptOut, false
+	if in == nil && isTerminal(os.Stdin) {
+		in, out, echo = os.Stdin, os.Stderr, true
+	}
+	if out == nil {
+		out = io.Discard
+	}
+
+	var missing []string
+	for _, sf := range structFields(v) {
+		question := sf.Tag.Get("prompt")
+		if in != nil && question != "" && !sources.IsSet(sf.Name) && sf.Value.IsZero() {
+			secret := echo && sf.Tag.Get("secret") == "true"
+			answer, err := prompt(in, out, question, secret)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %v", flagName(sf.StructField), err)
+			}
+			if answer != "" {
+				if err := setField(sf.Value, sf.Tag, answer, true); err != nil {
+					return fmt.Errorf("error setting field %s: %v", sf.Name, err)
+				}
+				sources.set(sf.Name, SourcePrompt)
+			}
+		}
+		if sf.Tag.Get("required") == "true" && sf.Value.IsZero() && !sources.IsSet(sf.Name) {
+			missing = append(missing, fmt.Sprintf("flag --%s is required", flagName(sf.StructField)))
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New(strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// prompt writes question to out and reads a line from r. With noEcho the
+// line is read from the terminal on os.Stdin without showing what is typed.
+func prompt(r io.Reader, out io.Writer, question string, noEcho bool) (string, error) {
+	fmt.Fprintf(out, "%s ", question)
+	if noEcho {
+		line, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(out)
+		return string(line), err
+	}
+	return readLine(r)
+}
+
+// readLine reads a line from r one byte at a time, so no input after the line
+// is consumed and a program can still read stdin after ParseAll.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r"), nil
+}
@@ -0,0 +1,41 @@
+package flag
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Result holds the results of ParseResult.
+type Result struct {
+	// RemainingArgs holds the positional arguments, in order.
+	RemainingArgs []string
+
+	// Flags holds the command-line flags in the order they were given,
+	// including flags that don't match any field.
+	Flags Flags
+
+	// Sources records the source that last set each field.
+	Sources Sources
+
+	// Warnings holds messages about the parsed values that are not errors,
+	// such as the use of deprecated flags.
+	Warnings []string
+}
+
+// deprecations returns a warning for every field tagged deprecated that was
+// set by env or a flag. The tag holds a hint like "use --addr instead".
+func deprecations(config interface{}, sources Sources) []string {
+	var warnings []string
+	for _, sf := range structFields(reflect.Indirect(reflect.ValueOf(config))) {
+		hint, ok := sf.Tag.Lookup("deprecated")
+		if !ok || !sources.IsSet(sf.Name) {
+			continue
+		}
+		warning := fmt.Sprintf("flag --%s is deprecated", flagName(sf.StructField))
+		if hint != "" {
+			warning += ": " + hint
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
@@ -0,0 +1,39 @@
+package flag_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/bartdeboer/flag"
+)
+
+func TestParseResult(t *testing.T) {
+	type Config struct {
+		Port    int    `default:"8080"`
+		Listen  string `deprecated:"use --addr instead"`
+		Addr    string
+		Verbose bool `short:"v"`
+	}
+
+	var config Config
+	result, err := ParseResult(&config, []string{"serve", "now", "--listen", ":80", "-v"})
+	if err != nil {
+		t.Fatalf("ParseResult failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(result.RemainingArgs, []string{"serve", "now"}) {
+		t.Errorf("Expected remaining args [serve now], got %v", result.RemainingArgs)
+	}
+	expectedFlags := Flags{{"listen", ":80", true, "--listen", 0}, {"v", "", false, "-v", 1}}
+	if !reflect.DeepEqual(result.Flags, expectedFlags) {
+		t.Errorf("Expected flags %v, got %v", expectedFlags, result.Flags)
+	}
+	expectedSources := Sources{"Port": SourceDefault, "Listen": SourceFlag, "Verbose": SourceFlag}
+	if !reflect.DeepEqual(result.Sources, expectedSources) {
+		t.Errorf("Expected sources %v, got %v", expectedSources, result.Sources)
+	}
+	expectedWarnings := []string{"flag --listen is deprecated: use --addr instead"}
+	if !reflect.DeepEqual(result.Warnings, expectedWarnings) {
+		t.Errorf("Expected warnings %v, got %v", expectedWarnings, result.Warnings)
+	}
+}
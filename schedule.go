@@ -0,0 +1,64 @@
+package flag
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeOrDuration is a field type holding either a duration, such as 30m or
+// -1h, or a clock time, such as 14:30 or 14:30:15. Resolve turns either into
+// an absolute time, so --at=30m means 30 minutes from now and --at=14:30
+// means today at 14:30.
+type TimeOrDuration struct {
+	// Duration is the duration, or the time since midnight when IsClock is
+	// true.
+	Duration time.Duration
+
+	// IsClock is true for a clock time and false for a duration.
+	IsClock bool
+}
+
+// clockLayouts are the layouts accepted for a clock time, in order.
+var clockLayouts = []string{"15:04", "15:04:05"}
+
+// UnmarshalText parses a duration, or a clock time when the text is not a
+// valid duration.
+func (t *TimeOrDuration) UnmarshalText(text []byte) error {
+	s := string(text)
+	if d, err := time.ParseDuration(s); err == nil {
+		*t = TimeOrDuration{Duration: d}
+		return nil
+	}
+	for _, layout := range clockLayouts {
+		if clock, err := time.Parse(layout, s); err == nil {
+			d := time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute + time.Duration(clock.Second())*time.Second
+			*t = TimeOrDuration{Duration: d, IsClock: true}
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid time or duration %q", s)
+}
+
+// String returns the value in a form that UnmarshalText parses back.
+func (t TimeOrDuration) String() string {
+	if !t.IsClock {
+		return t.Duration.String()
+	}
+	clock := time.Time{}.Add(t.Duration)
+	if clock.Second() != 0 {
+		return clock.Format("15:04:05")
+	}
+	return clock.Format("15:04")
+}
+
+// Resolve returns the absolute time the value refers to: now plus the
+// duration, or the clock time on the day of now, in the location of now. On
+// days with a daylight saving change 14:30 is still 14:30 on the wall clock.
+func (t TimeOrDuration) Resolve(now time.Time) time.Time {
+	if !t.IsClock {
+		return now.Add(t.Duration)
+	}
+	year, month, day := now.Date()
+	hour, min, sec := int(t.Duration/time.Hour), int(t.Duration/time.Minute%60), int(t.Duration/time.Second%60)
+	return time.Date(year, month, day, hour, min, sec, 0, now.Location())
+}
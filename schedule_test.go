@@ -0,0 +1,60 @@
+package flag_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/bartdeboer/flag"
+)
+
+func TestTimeOrDuration(t *testing.T) {
+	type Config struct {
+		At TimeOrDuration `default:"30m"`
+	}
+
+	now := time.Date(2024, 5, 1, 9, 15, 0, 0, time.UTC)
+	tests := []struct {
+		args     []string
+		expected time.Time
+	}{
+		{nil, now.Add(30 * time.Minute)},
+		{[]string{"--at=-1h"}, now.Add(-time.Hour)},
+		{[]string{"--at=14:30"}, time.Date(2024, 5, 1, 14, 30, 0, 0, time.UTC)},
+		{[]string{"--at=06:05:30"}, time.Date(2024, 5, 1, 6, 5, 30, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		var config Config
+		if _, _, err := ParseAll(&config, tt.args); err != nil {
+			t.Fatalf("ParseAll(%v) failed: %v", tt.args, err)
+		}
+		if at := config.At.Resolve(now); !at.Equal(tt.expected) {
+			t.Errorf("ParseAll(%v): expected %v, got %v", tt.args, tt.expected, at)
+		}
+	}
+
+	// On a daylight saving day the clock time is kept
+	if ny, err := time.LoadLocation("America/New_York"); err == nil {
+		var config Config
+		if _, _, err := ParseAll(&config, []string{"--at=14:30"}); err != nil {
+			t.Fatalf("ParseAll failed: %v", err)
+		}
+		at := config.At.Resolve(time.Date(2024, 3, 10, 9, 0, 0, 0, ny))
+		if expected := time.Date(2024, 3, 10, 14, 30, 0, 0, ny); !at.Equal(expected) {
+			t.Errorf("Expected %v on the DST day, got %v", expected, at)
+		}
+	}
+
+	var config Config
+	if err := SetDefaults(&config); err != nil {
+		t.Fatalf("SetDefaults failed: %v", err)
+	}
+	if config.At.IsClock || config.At.Duration != 30*time.Minute {
+		t.Errorf("Expected a default of 30m, got %+v", config.At)
+	}
+
+	_, _, err := ParseAll(&Config{}, []string{"--at=lunch"})
+	if err == nil || !strings.Contains(err.Error(), `invalid time or duration "lunch"`) {
+		t.Errorf("Expected error for an invalid value, got %v", err)
+	}
+}
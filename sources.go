@@ -0,0 +1,45 @@
+package flag
+
+// Source identifies where the value of a field came from.
+type Source int
+
+const (
+	SourceDefault Source = iota + 1 // Set from the default tag
+	SourceEnv                       // Set from an environment variable
+	SourceFlag                      // Set from a command-line flag
+	SourcePrompt                    // Read from an interactive prompt
+	SourceArg                       // Set from a positional argument
+)
+
+// String returns the name of the source.
+func (s Source) String() string {
+	switch s {
+	case SourceDefault:
+		return "default"
+	case SourceEnv:
+		return "env"
+	case SourceFlag:
+		return "flag"
+	case SourcePrompt:
+		return "prompt"
+	case SourceArg:
+		return "arg"
+	}
+	return "unset"
+}
+
+// Sources records the source that last set each field, keyed by field name.
+// Fields that were never set are absent.
+type Sources map[string]Source
+
+// IsSet reports whether the field was set explicitly, by env, a flag, a
+// prompt or a positional argument, rather than left at its default.
+func (s Sources) IsSet(field string) bool {
+	return s[field] > SourceDefault
+}
+
+func (s Sources) set(field string, source Source) {
+	if s != nil {
+		s[field] = source
+	}
+}
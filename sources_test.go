@@ -0,0 +1,104 @@
+package flag_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	. "github.com/bartdeboer/flag"
+)
+
+func TestParseAllSources(t *testing.T) {
+	type Config struct {
+		PortNumber int    `default:"8080"`
+		HostName   string `default:"localhost"`
+		LogLevel   string `default:"info"`
+		Debug      bool
+	}
+
+	os.Setenv("HOST_NAME", "example.com")
+	defer os.Unsetenv("HOST_NAME")
+
+	var config Config
+	_, _, sources, err := ParseAllSources(&config, []string{"--log-level=debug"})
+	if err != nil {
+		t.Fatalf("ParseAllSources failed: %v", err)
+	}
+
+	expected := Sources{
+		"PortNumber": SourceDefault,
+		"HostName":   SourceEnv,
+		"LogLevel":   SourceFlag,
+	}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("Expected sources %v, got %v", expected, sources)
+	}
+	if sources.IsSet("PortNumber") {
+		t.Errorf("Expected defaulted PortNumber not to be reported as set")
+	}
+	if !sources.IsSet("HostName") || !sources.IsSet("LogLevel") {
+		t.Errorf("Expected HostName and LogLevel to be reported as set")
+	}
+	if _, exists := sources["Debug"]; exists {
+		t.Errorf("Expected unset Debug to be absent, got %v", sources["Debug"])
+	}
+}
+
+func TestSetPrecedence(t *testing.T) {
+	type Config struct {
+		Port int    `default:"8080" env:"PRECEDENCE_PORT"`
+		Host string `default:"localhost" env:"PRECEDENCE_HOST"`
+	}
+
+	os.Setenv("PRECEDENCE_PORT", "3000")
+	os.Setenv("PRECEDENCE_HOST", "env.example.com")
+	defer os.Unsetenv("PRECEDENCE_PORT")
+	defer os.Unsetenv("PRECEDENCE_HOST")
+	args := []string{"--port=9090"}
+
+	var config Config
+	if _, _, err := ParseAll(&config, args); err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	if config.Port != 9090 || config.Host != "env.example.com" {
+		t.Errorf("Expected flags over env, got %+v", config)
+	}
+
+	saved := Precedence()
+	defer SetPrecedence(saved)
+	if err := SetPrecedence([]Source{SourceDefault, SourceFlag, SourceEnv}); err != nil {
+		t.Fatalf("SetPrecedence failed: %v", err)
+	}
+
+	config = Config{}
+	_, _, sources, err := ParseAllSources(&config, args)
+	if err != nil {
+		t.Fatalf("ParseAllSources failed: %v", err)
+	}
+	if config.Port != 3000 || sources["Port"] != SourceEnv {
+		t.Errorf("Expected env over flags, got port %d from %s", config.Port, sources["Port"])
+	}
+
+	for _, order := range [][]Source{
+		{SourceDefault, SourceEnv},
+		{SourceDefault, SourceEnv, SourceEnv},
+		{SourceDefault, SourceEnv, SourcePrompt},
+		{SourceEnv, SourceFlag, SourceDefault},
+	} {
+		if err := SetPrecedence(order); err == nil {
+			t.Errorf("SetPrecedence(%v): expected an error", order)
+		}
+	}
+
+	// Skipping set fields lets the first source win: flags over env
+	SetSkipSetFields(true)
+	defer SetSkipSetFields(false)
+	config = Config{}
+	_, _, sources, err = ParseAllSources(&config, args)
+	if err != nil {
+		t.Fatalf("ParseAllSources failed: %v", err)
+	}
+	if config.Port != 9090 || sources["Port"] != SourceFlag || config.Host != "env.example.com" {
+		t.Errorf("Expected port 9090 from the flag and host from env, got %+v from %v", config, sources)
+	}
+}
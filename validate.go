@@ -0,0 +1,88 @@
+package flag
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FieldFailure is a single failed validation rule.
+type FieldFailure struct {
+	Path  string      // Long flag name of the field, like log.level for nested fields
+	Rule  string      // Rule that failed: required, min, max or choices
+	Value interface{} // Offending value
+	Msg   string      // Description of the failure
+}
+
+// Error returns the path and the description of the failure.
+func (f FieldFailure) Error() string {
+	return f.Path + ": " + f.Msg
+}
+
+// ValidationError holds all failures found by Validate.
+type ValidationError struct {
+	Failures []FieldFailure
+}
+
+// Error returns the failures, separated by semicolons.
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Failures))
+	for i, failure := range e.Failures {
+		messages[i] = failure.Error()
+	}
+	return "invalid config: " + strings.Join(messages, "; ")
+}
+
+// Validate checks the fields of config against their tags and returns a
+// *ValidationError holding every failure, or nil when all fields are valid.
+// It checks required:"true", the min and max bounds of numbers and durations,
+// and choices, as in choices:"fast,slow", which lists the allowed values.
+// Bounds and choices apply to each element of a slice.
+func Validate(config interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(config))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a struct or a pointer to a struct, got %T", config)
+	}
+	var failures []FieldFailure
+	walkFields(v, "", func(sf structField, path string) error {
+		failures = append(failures, validateField(sf, path)...)
+		return nil
+	})
+	if len(failures) > 0 {
+		return &ValidationError{Failures: failures}
+	}
+	return nil
+}
+
+// validateField returns the failed rules of a single field. Bounds are
+// checked for every value, while choices are not checked for a zero value.
+func validateField(sf structField, path string) []FieldFailure {
+	if sf.Value.IsZero() && sf.Tag.Get("required") == "true" {
+		return []FieldFailure{{path, "required", sf.Value.Interface(), "value is required"}}
+	}
+	value := reflect.Indirect(sf.Value)
+	if !value.IsValid() {
+		return nil // A nil pointer has no value to check
+	}
+	elems := []reflect.Value{value}
+	if value.Kind() == reflect.Slice && value.Type() != ipType {
+		elems = make([]reflect.Value, value.Len())
+		for i := range elems {
+			elems[i] = value.Index(i)
+		}
+	}
+
+	var failures []FieldFailure
+	for _, elem := range elems {
+		checks := []func(reflect.Value, reflect.StructTag) error{checkRange}
+		if !elem.IsZero() {
+			checks = append(checks, checkChoices)
+		}
+		for _, check := range checks {
+			if err, ok := check(elem, sf.Tag).(*ruleError); ok {
+				failures = append(failures, FieldFailure{path, err.rule, elem.Interface(), err.msg})
+			}
+		}
+	}
+	return failures
+}
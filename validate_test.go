@@ -0,0 +1,56 @@
+package flag_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/bartdeboer/flag"
+)
+
+func TestValidate(t *testing.T) {
+	type LogConfig struct {
+		Level string `choices:"debug,info,warn"`
+	}
+	type Config struct {
+		Name    string `required:"true"`
+		Workers int    `min:"1" max:"64"`
+		Log     LogConfig
+	}
+
+	if err := Validate(&Config{Name: "api", Workers: 4, Log: LogConfig{Level: "info"}}); err != nil {
+		t.Errorf("Expected a valid config, got %v", err)
+	}
+
+	err := Validate(&Config{Workers: 100, Log: LogConfig{Level: "trace"}})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	expected := []FieldFailure{
+		{Path: "name", Rule: "required", Value: "", Msg: "value is required"},
+		{Path: "workers", Rule: "max", Value: 100, Msg: "100 is above the maximum of 64"},
+		{Path: "log.level", Rule: "choices", Value: "trace", Msg: `"trace" is not one of debug, info, warn`},
+	}
+	if !reflect.DeepEqual(validationErr.Failures, expected) {
+		t.Errorf("Expected failures %+v, got %+v", expected, validationErr.Failures)
+	}
+	for _, path := range []string{"name: ", "workers: ", "log.level: "} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("Expected %q in error %q", path, err)
+		}
+	}
+
+	// Bounds apply to zero values, choices don't
+	err = Validate(&Config{Name: "api"})
+	if !errors.As(err, &validationErr) || len(validationErr.Failures) != 1 || validationErr.Failures[0].Rule != "min" {
+		t.Errorf("Expected only the min rule to fail for 0 workers, got %v", err)
+	}
+
+	// ParseAll enforces choices as well
+	_, _, err = ParseAll(&Config{}, []string{"--name=api", "--workers=1", "--log.level=trace"})
+	if err == nil || !strings.Contains(err.Error(), `"trace" is not one of debug, info, warn`) {
+		t.Errorf("Expected ParseAll to reject an invalid choice, got %v", err)
+	}
+}